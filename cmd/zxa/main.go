@@ -3,6 +3,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -18,13 +19,48 @@ var version = "v0.1.0-dev"
 type Config struct {
 	inputFile    string
 	outputFile   string
-	includePaths []string
+	includePaths pathList
 	hexOutput    bool
 	jsonOutput   bool
+	symOutput    bool
+	lstOutput    bool
+	segments     bool
 	verbose      bool
 	z80next      bool
+	undoc        bool
 	quiet        bool
 	debug        bool
+	maxErrors    int
+	defines      defineList
+}
+
+// defineList collects repeated -D NAME[=value] flags
+type defineList []string
+
+func (d *defineList) String() string {
+	return strings.Join(*d, ",")
+}
+
+func (d *defineList) Set(value string) error {
+	*d = append(*d, value)
+	return nil
+}
+
+// pathList collects repeated -I flags, each of which may hold several
+// directories separated as in PATH
+type pathList []string
+
+func (l *pathList) String() string {
+	return strings.Join(*l, string(os.PathListSeparator))
+}
+
+func (l *pathList) Set(value string) error {
+	for _, path := range filepath.SplitList(value) {
+		if path != "" {
+			*l = append(*l, path)
+		}
+	}
+	return nil
 }
 
 func printUsage() {
@@ -42,13 +78,19 @@ func parseFlags() (*Config, error) {
 
 	// Define flags
 	outFile := flag.String("o", "", "output file name (default: input base name)")
-	includePath := flag.String("I", "", "include search path (can be specified multiple times)")
+	flag.Var(&cfg.includePaths, "I", "add directories to the include search path (can be specified multiple times)")
 	flag.BoolVar(&cfg.hexOutput, "hex", false, "generate hex dump output")
 	flag.BoolVar(&cfg.jsonOutput, "json", false, "generate JSON assembly report")
+	flag.BoolVar(&cfg.symOutput, "sym", false, "generate symbol file")
+	flag.BoolVar(&cfg.lstOutput, "lst", false, "generate assembly listing")
+	flag.BoolVar(&cfg.segments, "segments", false, "write one binary file per contiguous segment instead of a gap-filled image")
 	flag.BoolVar(&cfg.verbose, "v", false, "enable verbose output")
 	flag.BoolVar(&cfg.z80next, "next", false, "enable Z80N (ZX Spectrum Next) instructions")
+	flag.BoolVar(&cfg.undoc, "undoc", false, "enable undocumented Z80 instructions (IXH/IXL, SLL, ...)")
 	flag.BoolVar(&cfg.quiet, "q", false, "quiet mode (suppress non-error output)")
 	flag.BoolVar(&cfg.debug, "debug", false, "enable debug output")
+	flag.Var(&cfg.defines, "D", "predefine a symbol as NAME or NAME=value (can be specified multiple times)")
+	flag.IntVar(&cfg.maxErrors, "maxerrors", 20, "maximum number of errors to print (0 for no limit)")
 	showVersion := flag.Bool("version", false, "show version information")
 
 	// Custom usage message
@@ -76,21 +118,8 @@ func parseFlags() (*Config, error) {
 		cfg.outputFile = base
 	}
 
-	// Process include paths
-	if *includePath != "" {
-		cfg.includePaths = strings.Split(*includePath, string(os.PathListSeparator))
-	}
-
-	// Add current directory to include paths if not present
-	hasCurrentDir := false
-	for _, path := range cfg.includePaths {
-		if path == "." {
-			hasCurrentDir = true
-			break
-		}
-	}
-	if !hasCurrentDir {
-		cfg.includePaths = append([]string{"."}, cfg.includePaths...)
+	if cfg.maxErrors < 0 {
+		return nil, fmt.Errorf("maximum error count cannot be negative: %d", cfg.maxErrors)
 	}
 
 	// Verbose and quiet are mutually exclusive
@@ -101,6 +130,41 @@ func parseFlags() (*Config, error) {
 	return cfg, nil
 }
 
+// printErrors reports a failed assembly, listing every collected error up
+// to maxErrors (0 prints them all)
+func printErrors(err error, maxErrors int) {
+	var list *zxa_assembler.ErrorList
+	if !errors.As(err, &list) {
+		fmt.Fprintf(os.Stderr, "Assembly failed: %v\n", err)
+		return
+	}
+
+	errs := list.Errors()
+	shown := errs
+	if maxErrors > 0 && len(shown) > maxErrors {
+		shown = shown[:maxErrors]
+	}
+	for _, e := range shown {
+		fmt.Fprintln(os.Stderr, e.Error())
+	}
+	if len(shown) < len(errs) {
+		fmt.Fprintf(os.Stderr, "... and %d more errors\n", len(errs)-len(shown))
+	}
+	fmt.Fprintf(os.Stderr, "Assembly failed: %d error(s)\n", len(errs))
+}
+
+// printMessages shows the DISPLAY notes and WARNING messages of the
+// assembly; quiet mode keeps only the warnings
+func printMessages(msgs []zxa_assembler.AssemblerError, quiet bool) {
+	for _, msg := range msgs {
+		if msg.Category == zxa_assembler.Warning {
+			fmt.Fprintln(os.Stderr, msg.Error())
+		} else if !quiet {
+			fmt.Println(msg.Error())
+		}
+	}
+}
+
 func main() {
 	startTime := time.Now()
 
@@ -114,8 +178,9 @@ func main() {
 
 	// Create assembler options
 	opts := zxa_assembler.AssemblerOptions{
-		Variant: zxa_assembler.Z80Standard,
-		Debug:   cfg.debug,
+		Variant:      zxa_assembler.Z80Standard,
+		Undocumented: cfg.undoc,
+		Debug:        cfg.debug,
 	}
 	if cfg.z80next {
 		opts.Variant = zxa_assembler.Z80Next
@@ -127,9 +192,24 @@ func main() {
 	// Configure assembler
 	asm.SetHexOutput(cfg.hexOutput)
 	asm.SetJSONOutput(cfg.jsonOutput)
+	asm.SetSymbolOutput(cfg.symOutput)
+	asm.SetListingOutput(cfg.lstOutput)
+	asm.SetSegmentOutput(cfg.segments)
 	for _, path := range cfg.includePaths {
 		asm.AddIncludePath(path)
 	}
+	for _, def := range cfg.defines {
+		// -D NAME defines NAME as 1, but -D NAME= is missing its value
+		name, value, found := strings.Cut(def, "=")
+		if found && value == "" {
+			fmt.Fprintf(os.Stderr, "Error: -D %s: missing value after =\n", def)
+			os.Exit(1)
+		}
+		if err := asm.Define(name, value); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: -D %s: %v\n", def, err)
+			os.Exit(1)
+		}
+	}
 
 	// Print configuration if verbose
 	if cfg.verbose {
@@ -143,24 +223,41 @@ func main() {
 				fmt.Printf("  %s\n", path)
 			}
 		}
-		fmt.Printf("Output formats: binary")
+		if cfg.segments {
+			fmt.Printf("Output formats: binary per segment")
+		} else {
+			fmt.Printf("Output formats: binary")
+		}
 		if cfg.hexOutput {
 			fmt.Printf(", hex")
 		}
 		if cfg.jsonOutput {
 			fmt.Printf(", json")
 		}
+		if cfg.symOutput {
+			fmt.Printf(", sym")
+		}
+		if cfg.lstOutput {
+			fmt.Printf(", lst")
+		}
 		fmt.Printf("\n")
 		if cfg.z80next {
 			fmt.Printf("Z80N instructions enabled\n")
 		}
+		if cfg.undoc {
+			fmt.Printf("Undocumented instructions enabled\n")
+		}
+		for _, def := range cfg.defines {
+			fmt.Printf("Defined: %s\n", def)
+		}
 		fmt.Printf("\n")
 	}
 
 	// Perform assembly
 	result, err := asm.Assemble(cfg.inputFile)
+	printMessages(asm.Messages(), cfg.quiet)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Assembly failed: %v\n", err)
+		printErrors(err, cfg.maxErrors)
 		os.Exit(1)
 	}
 
@@ -173,6 +270,26 @@ func main() {
 	// Print statistics unless quiet mode
 	if !cfg.quiet {
 		if cfg.verbose {
+			fmt.Printf("Segments:\n")
+			for _, seg := range result.Segments {
+				fmt.Printf("  $%04X-$%04X (%d bytes)\n", seg.Start, seg.End, seg.Length)
+			}
+			if result.Entry != nil {
+				fmt.Printf("Entry point: $%04X\n", *result.Entry)
+			}
+			if len(result.IncludedFiles) > 0 {
+				fmt.Printf("Included files:\n")
+				for _, inc := range result.IncludedFiles {
+					fmt.Printf("  %s -> %s\n", inc.Name, inc.Path)
+				}
+			}
+			if len(result.OutputFiles) > 0 {
+				fmt.Printf("Output files:\n")
+				for _, out := range result.OutputFiles {
+					fmt.Printf("  %s: %s (%d bytes)\n", out.Format, out.Path, out.Size)
+				}
+			}
+
 			fmt.Printf("\nAssembly statistics:\n")
 			fmt.Printf("  Bytes generated: %d\n", result.Statistics.BytesGenerated)
 			fmt.Printf("  Lines processed: %d\n", result.Statistics.LinesProcessed)
@@ -189,4 +306,4 @@ func main() {
 	if !result.Success {
 		os.Exit(1)
 	}
-}
\ No newline at end of file
+}
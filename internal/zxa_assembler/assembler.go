@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -18,8 +19,11 @@ const (
 	RegisterPair                           // Register pair
 	Immediate                              // 8-bit immediate
 	ImmediateExt                           // 16-bit immediate
+	ImmediateExtBE                         // 16-bit immediate stored high byte first
+	ImmediatePair                          // Two 8-bit immediates
 	Extended                               // Extended addressing
 	Indexed                                // Indexed addressing (IX+d, IY+d)
+	IndexedImmediate                       // Indexed addressing followed by an 8-bit immediate
 	IndexedBit                             // Bit operations with indexed addressing
 	Relative                               // Relative addressing (for jr, djnz)
 	RegisterIndirect                       // Register indirect (HL)
@@ -36,9 +40,10 @@ const (
 
 // Symbol represents a label or constant in the assembly
 type Symbol struct {
-	Name  string
-	Value int
-	Type  string // "label", "equ", or "forward"
+	Name     string
+	Value    int
+	Type     string // "label", "equ", "set", "struct" or "field"
+	Physical *int   `json:",omitempty"` // Where a label inside PHASE is stored
 }
 
 // Instruction represents a Z80 instruction definition
@@ -54,35 +59,53 @@ type Instruction struct {
 // InstructionMap holds all Z80 instructions indexed by mnemonic
 type InstructionMap map[string]Instruction
 
+// mnemonics returns the set of mnemonics in the map, without operands
+func (m InstructionMap) mnemonics() map[string]bool {
+	set := make(map[string]bool)
+	for key := range m {
+		mnemonic, _, _ := strings.Cut(key, " ")
+		set[mnemonic] = true
+	}
+	return set
+}
+
 // AssemblerOptions contains configuration for the assembler
 type AssemblerOptions struct {
-	Variant CPUVariant
-	Debug bool
+	Variant      CPUVariant
+	Undocumented bool // Accept undocumented instructions such as SLL and IXH
+	Debug        bool
 }
 
-// ForwardRef represents a forward reference to be resolved
-type ForwardRef struct {
-	Address int            // Where to patch
-	Type    AddressingMode // How to patch (relative vs absolute)
-	Length  int            // How many bytes to patch
-	Target  string         // Target symbol name
+// BinaryFile represents a binary file included with INCBIN
+type BinaryFile struct {
+	Filename string `json:"filename"`
+	Offset   int    `json:"address"` // Address the first byte was placed at
+	Skip     int    `json:"skip"`    // Bytes skipped from start of file
+	Length   int    `json:"length"`  // Bytes included
 }
 
-// BinaryFile represents a binary file to be included
-type BinaryFile struct {
-	Filename string
-	Offset   int // Where to include in output
-	Skip     int // Bytes to skip from start of file
-	Length   int // Bytes to include (-1 for all)
+// IncludedFile records where an INCLUDE or INCBIN file was found
+type IncludedFile struct {
+	Name string `json:"name"` // Filename as written in the source
+	Path string `json:"path"` // Path the file was read from
+	Kind string `json:"kind"` // "source" or "binary"
 }
 
 // AssemblyResult represents the result of assembly
 type AssemblyResult struct {
-	Success    bool          `json:"success"`
-	Binary     []byte        `json:"-"`
-	HexDump    string        `json:"hexdump,omitempty"`
-	JSONReport string        `json:"report,omitempty"`
-	Statistics AssemblyStats `json:"statistics"`
+	Success       bool           `json:"success"`
+	Binary        []byte         `json:"-"`               // Lowest to highest written address, gaps zero-filled
+	Origin        int            `json:"origin"`          // Address of the first byte of Binary
+	Entry         *int           `json:"entry,omitempty"` // Execution address from END, if given
+	Segments      []Segment      `json:"segments,omitempty"`
+	SplitSegments bool           `json:"-"` // Write one binary file per segment
+	SymbolFile    string         `json:"-"` // Symbol table as NAME EQU value lines
+	Listing       string         `json:"-"`
+	HexDump       string         `json:"hexdump,omitempty"`
+	JSONReport    string         `json:"report,omitempty"`
+	Statistics    AssemblyStats  `json:"statistics"`
+	IncludedFiles []IncludedFile `json:"includedFiles,omitempty"`
+	OutputFiles   []OutputFile   `json:"outputFiles,omitempty"` // From SAVEBIN and the like
 }
 
 // AssemblyStats contains assembly statistics
@@ -92,33 +115,74 @@ type AssemblyStats struct {
 	SymbolsDefined int `json:"symbolsDefined"`
 }
 
+// Assembly passes. Pass 1 only sizes instructions and records symbols;
+// pass 2 emits bytes with every symbol known.
+const (
+	firstPass = 1
+	finalPass = 2
+)
+
 // Assembler represents the assembler state
 type Assembler struct {
 	instructions InstructionMap
-	output       []byte
-	currentAddr  int
-	currentLabel string
+	undocumented InstructionMap
+	mnemonics    map[string]bool
+	pass         int
+	memory       *Memory
+	memoryErr    *memoryError
+	currentAddr  int             // Physical address the next byte is stored at
+	phase        *SourceLocation // Open PHASE directive, if any
+	phaseOffset  int             // Logical minus physical address inside PHASE
+	currentLabel string          // Last non-local label, the scope of .local labels
 	symbols      map[string]Symbol
-	forwardRefs  []ForwardRef
+	defined      map[string]bool // Symbols defined so far in this pass
+	predefined   []string        // Symbols set with Define before assembly
 	originSet    bool
 	includes     map[string]bool
+	includeStack []SourceLocation // INCLUDEs and macro calls being processed
+	macros       map[string]*Macro
+	structs      map[string]*Struct
+	charmap      *charmap // Translation of string characters to bytes
+	charmaps     map[string]*charmap
+	macroStack   []string         // Macros being expanded, for the depth limit
+	expansions   int              // Macro expansions so far in this pass
+	generated    map[string]bool  // Names given to labels of macro and repeat bodies
+	modules      []module         // Modules open at this point of the pass
+	anonymous    map[string][]int // Addresses of each anonymous label, from pass 1
+	anonSeen     map[string]int   // Anonymous labels passed so far in this pass
+	statements   int              // Statements parsed so far in this pass
+	extents      []extent         // How far each statement reached in pass 1, by number
 	includePath  []string
+	included     []IncludedFile
 	options      AssemblerOptions
 	binaryFiles  []BinaryFile
+	saves        []saveRequest   // Output files asked for in the final pass
+	entry        int             // Execution address given by END
+	entryFrom    *SourceLocation // END that gave the entry address, if any
 	hexOutput    bool
 	jsonOutput   bool
+	symOutput    bool
+	listOutput   bool
+	listing      []ListingLine
+	listIndex    int // Listing entry of the line being assembled, or -1
+	splitOutput  bool
+	errors       ErrorList
+	messages     ErrorList // Warnings and notes, which do not fail the assembly
 }
 
 // NewAssembler creates a new assembler instance
 func NewAssembler(opts AssemblerOptions) *Assembler {
 	a := &Assembler{
-		output:       make([]byte, 0, 1024),
+		memory:       &Memory{},
 		symbols:      make(map[string]Symbol),
-		forwardRefs:  make([]ForwardRef, 0),
+		defined:      make(map[string]bool),
+		macros:       make(map[string]*Macro),
+		anonymous:    make(map[string][]int),
+		generated:    make(map[string]bool),
 		includes:     make(map[string]bool),
-		includePath:  []string{"."},
 		options:      opts,
 		instructions: make(InstructionMap),
+		undocumented: make(InstructionMap),
 	}
 
 	// Initialize instruction set
@@ -131,74 +195,249 @@ func NewAssembler(opts AssemblerOptions) *Assembler {
 		a.instructions.initZ80NInstructions()
 	}
 
+	// Undocumented instructions are always known, so that using one without
+	// the option gives a clear error instead of an unknown mnemonic
+	a.undocumented.initUndocumentedInstructions()
+	if opts.Undocumented {
+		for key, inst := range a.undocumented {
+			a.instructions[key] = inst
+		}
+	}
+	a.mnemonics = a.instructions.mnemonics()
+	for mnemonic := range a.undocumented.mnemonics() {
+		a.mnemonics[mnemonic] = true
+	}
+
 	return a
 }
 
-// emitByte adds a byte to the output
+// emitByte stores a byte at the current address. During the first pass
+// only the address advances, so instructions are sized without being
+// emitted. Overlaps and writes past 64K are kept for takeMemoryError.
 func (a *Assembler) emitByte(b byte) {
-	a.output = append(a.output, b)
+	if a.pass == finalPass {
+		if a.currentAddr < 0 || a.currentAddr >= memorySize {
+			a.memoryFault().overflow = true
+		} else if !a.memory.write(a.currentAddr, b) {
+			fault := a.memoryFault()
+			if fault.overlapEnd < fault.overlapStart {
+				fault.overlapStart = a.currentAddr
+			}
+			fault.overlapEnd = a.currentAddr
+		}
+		a.listByte(b)
+	}
 	a.currentAddr++
 }
 
+// memoryFault returns the memory problems of the current statement
+func (a *Assembler) memoryFault() *memoryError {
+	if a.memoryErr == nil {
+		a.memoryErr = &memoryError{overlapStart: 0, overlapEnd: -1}
+	}
+	return a.memoryErr
+}
+
+// takeMemoryError returns and clears the memory problems caused by the
+// statement just assembled
+func (a *Assembler) takeMemoryError() error {
+	fault := a.memoryErr
+	if fault == nil {
+		return nil
+	}
+	a.memoryErr = nil
+	return rangeError("", 0, "%s", fault.message())
+}
+
+// resetPass clears the per-pass state before the source is read again
+func (a *Assembler) resetPass(pass int) {
+	a.pass = pass
+	a.memory = &Memory{}
+	a.memoryErr = nil
+	a.currentAddr = 0
+	a.phase = nil
+	a.phaseOffset = 0
+	a.listing = nil
+	a.listIndex = -1
+	a.currentLabel = ""
+	a.originSet = false
+	a.binaryFiles = nil
+	a.saves = nil
+	a.entry = 0
+	a.entryFrom = nil
+	a.includeStack = nil
+	a.included = nil
+	a.macros = make(map[string]*Macro)
+	a.structs = make(map[string]*Struct)
+	a.resetCharmaps()
+	a.macroStack = nil
+	a.expansions = 0
+	a.anonSeen = make(map[string]int)
+	a.modules = nil
+	a.statements = 0
+	if pass == firstPass {
+		a.extents = nil
+	}
+
+	// IFDEF sees a symbol from its definition on, in both passes alike
+	a.defined = make(map[string]bool)
+	for _, name := range a.predefined {
+		a.defined[name] = true
+	}
+}
+
+// Define predefines a symbol before assembly, as with -D NAME=value on the
+// command line. An empty value defines the symbol as 1.
+func (a *Assembler) Define(name, value string) error {
+	if !isSymbolName(name) {
+		return fmt.Errorf("invalid symbol name: %s", name)
+	}
+	n := int64(1)
+	if value != "" {
+		var err error
+		if n, err = parseNumber(value); err != nil {
+			return fmt.Errorf("invalid value for %s: %v", name, err)
+		}
+	}
+	if _, exists := a.symbols[name]; !exists {
+		a.predefined = append(a.predefined, name)
+	}
+	a.symbols[name] = Symbol{
+		Name:  name,
+		Value: int(n),
+		Type:  "equ",
+	}
+	return nil
+}
+
+// isDefined reports whether a symbol has been defined earlier in this pass
+func (a *Assembler) isDefined(name string) bool {
+	return a.defined[name]
+}
+
 // addSymbol adds a symbol to the symbol table
 func (a *Assembler) addSymbol(name string, value int) error {
-	if _, exists := a.symbols[name]; exists {
+	a.defined[name] = true
+	if sym, exists := a.symbols[name]; exists {
+		// The final pass sees every label again; it must land where pass 1 put it
+		if a.pass == finalPass {
+			// After an error in this pass the error itself explains the move
+			if sym.Value != value && !a.errors.HasErrors() {
+				return fmt.Errorf("phase error: %s moved from $%04X to $%04X between passes",
+					name, sym.Value, value)
+			}
+			return nil
+		}
+		if sym.Type != "label" {
+			return fmt.Errorf("%s is already defined as %s", name, symbolKind(sym.Type))
+		}
+		if a.isGenerated(name) {
+			return fmt.Errorf("duplicate symbol: %s (also the name a macro or repeat expansion gives a label)",
+				name)
+		}
 		return fmt.Errorf("duplicate symbol: %s", name)
 	}
-	a.symbols[name] = Symbol{
+	sym := Symbol{
 		Name:  name,
 		Value: value,
 		Type:  "label",
 	}
-	return nil
-}
-
-// updateSymbol updates an existing symbol's value
-func (a *Assembler) updateSymbol(name string, value int) error {
-	if _, exists := a.symbols[name]; !exists {
-		return fmt.Errorf("undefined symbol: %s", name)
+	if a.phase != nil {
+		physical := value - a.phaseOffset
+		sym.Physical = &physical
 	}
-	sym := a.symbols[name]
-	sym.Value = value
 	a.symbols[name] = sym
 	return nil
 }
 
-// addForwardRef adds a forward reference to be resolved later
-func (a *Assembler) addForwardRef(target string, addr int, mode AddressingMode, length int) {
-	a.forwardRefs = append(a.forwardRefs, ForwardRef{
-		Address: addr,
-		Type:    mode,
-		Length:  length,
-		Target:  target,
-	})
+// extent records how far a statement reached in pass 1: the bytes it took
+// and the number of statements parsed by its end, including those of a
+// macro or repeat body it expanded
+type extent struct {
+	size       int
+	statements int
 }
 
-// resolveForwardRefs resolves all forward references
-func (a *Assembler) resolveForwardRefs() error {
-	for _, ref := range a.forwardRefs {
-		sym, exists := a.symbols[ref.Target]
-		if !exists {
-			return fmt.Errorf("undefined symbol: %s", ref.Target)
-		}
+// beginStatement numbers the statement about to be parsed, returning its
+// number and the address it starts at
+func (a *Assembler) beginStatement() (int, int) {
+	a.statements++
+	return a.statements - 1, a.currentAddr
+}
 
-		switch ref.Type {
-		case Relative:
-			offset := sym.Value - (ref.Address + 2)
-			if offset < -128 || offset > 127 {
-				return fmt.Errorf("relative jump out of range to %s", ref.Target)
-			}
-			a.output[ref.Address] = byte(offset)
+// endStatement records the extent of a statement in pass 1. A statement
+// that fails in the final pass is given the extent pass 1 found for it, so
+// the code after it stays where pass 1 put it and one error does not turn
+// into a phase error on every label that follows.
+func (a *Assembler) endStatement(n, start int, failed bool) {
+	if a.pass != finalPass {
+		for len(a.extents) <= n {
+			a.extents = append(a.extents, extent{})
+		}
+		a.extents[n] = extent{a.currentAddr - start, a.statements}
+		return
+	}
+	if failed && n < len(a.extents) {
+		a.currentAddr = start + a.extents[n].size
+		a.statements = a.extents[n].statements
+	}
+}
 
-		case Extended, ImmediateExt:
-			value := uint16(sym.Value)
-			a.output[ref.Address] = byte(value)
-			a.output[ref.Address+1] = byte(value >> 8)
+// defineAnonymous records an anonymous label such as 1: at the current
+// address. Pass 1 lists every occurrence so that 1f can look ahead.
+func (a *Assembler) defineAnonymous(name string) error {
+	n := a.anonSeen[name]
+	a.anonSeen[name] = n + 1
+	if a.pass != finalPass {
+		a.anonymous[name] = append(a.anonymous[name], a.logicalAddr())
+		return nil
+	}
+	addrs := a.anonymous[name]
+	if (n >= len(addrs) || addrs[n] != a.logicalAddr()) && !a.errors.HasErrors() {
+		return fmt.Errorf("phase error: anonymous label %s: moved between passes", name)
+	}
+	return nil
+}
 
-		default:
-			a.output[ref.Address] = byte(sym.Value)
+// anonymousLabel returns the address of the nearest anonymous label before
+// or after the current statement. A forward label is unknown until pass 1
+// has seen it, which is reported as not ok rather than as an error.
+func (a *Assembler) anonymousLabel(name string, forward bool) (int, bool, error) {
+	n := a.anonSeen[name]
+	addrs := a.anonymous[name]
+	if !forward {
+		if n == 0 {
+			return 0, false, fmt.Errorf("no anonymous label %s: before this line", name)
 		}
+		return addrs[n-1], true, nil
+	}
+	if n < len(addrs) {
+		return addrs[n], true, nil
+	}
+	if a.pass == finalPass {
+		return 0, false, fmt.Errorf("no anonymous label %s: after this line", name)
 	}
+	return 0, false, nil
+}
+
+// setConstant defines or redefines a symbol that is not an address
+func (a *Assembler) setConstant(name string, value int, kind string) {
+	a.defined[name] = true
+	a.symbols[name] = Symbol{
+		Name:  name,
+		Value: value,
+		Type:  kind,
+	}
+}
+
+// updateSymbol updates an existing symbol's value
+func (a *Assembler) updateSymbol(name string, value int) error {
+	if _, exists := a.symbols[name]; !exists {
+		return fmt.Errorf("undefined symbol: %s", name)
+	}
+	sym := a.symbols[name]
+	sym.Value = value
+	a.symbols[name] = sym
 	return nil
 }
 
@@ -233,48 +472,123 @@ func (a *Assembler) SetJSONOutput(enabled bool) {
 	a.jsonOutput = enabled
 }
 
-// generateHexDump creates a hex dump of the output
+// SetSymbolOutput configures symbol file output
+func (a *Assembler) SetSymbolOutput(enabled bool) {
+	a.symOutput = enabled
+}
+
+// SetSegmentOutput configures writing one binary file per contiguous
+// segment instead of a single gap-filled image
+func (a *Assembler) SetSegmentOutput(enabled bool) {
+	a.splitOutput = enabled
+}
+
+// generateHexDump creates a hex dump of the output, one block per
+// segment, addressed as in memory
 func (a *Assembler) generateHexDump() string {
 	var sb strings.Builder
 	const bytesPerLine = 16
 
-	for i := 0; i < len(a.output); i += bytesPerLine {
-		// Write address
-		fmt.Fprintf(&sb, "%04X: ", i)
-
-		// Write hex bytes
-		for j := 0; j < bytesPerLine; j++ {
-			if i+j < len(a.output) {
-				fmt.Fprintf(&sb, "%02X ", a.output[i+j])
-			} else {
-				sb.WriteString("   ")
-			}
+	for n, seg := range a.memory.segments() {
+		if n > 0 {
+			sb.WriteString("\n")
 		}
+		data := seg.Data
+
+		for i := 0; i < len(data); i += bytesPerLine {
+			// Write address
+			fmt.Fprintf(&sb, "%04X: ", seg.Start+i)
+
+			// Write hex bytes
+			for j := 0; j < bytesPerLine; j++ {
+				if i+j < len(data) {
+					fmt.Fprintf(&sb, "%02X ", data[i+j])
+				} else {
+					sb.WriteString("   ")
+				}
+			}
 
-		// Write ASCII representation
-		sb.WriteString(" |")
-		for j := 0; j < bytesPerLine && i+j < len(a.output); j++ {
-			b := a.output[i+j]
-			if b >= 32 && b <= 126 {
-				sb.WriteByte(b)
-			} else {
-				sb.WriteByte('.')
+			// Write ASCII representation
+			sb.WriteString(" |")
+			for j := 0; j < bytesPerLine && i+j < len(data); j++ {
+				b := data[i+j]
+				if b >= 32 && b <= 126 {
+					sb.WriteByte(b)
+				} else {
+					sb.WriteByte('.')
+				}
 			}
+			sb.WriteString("|\n")
 		}
-		sb.WriteString("|\n")
 	}
 
 	return sb.String()
 }
 
+// generateSymbolFile lists the symbols in name order in the NAME EQU value
+// form pasmo writes, with local labels under their qualified names
+func (a *Assembler) generateSymbolFile() string {
+	symbols := a.exportedSymbols()
+	names := make([]string, 0, len(symbols))
+	for name := range symbols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		sym := symbols[name]
+		fmt.Fprintf(&sb, "%s\tEQU 0%04XH", name, uint16(sym.Value))
+		if sym.Physical != nil {
+			fmt.Fprintf(&sb, "\t; stored at 0%04XH", *sym.Physical)
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// exportedSymbols returns the symbols for the symbol file and report,
+// leaving out the names labels of macro and repeat bodies take in each
+// expansion, whether in a module, as a local label or as a scope
+func (a *Assembler) exportedSymbols() map[string]Symbol {
+	symbols := make(map[string]Symbol, len(a.symbols))
+	for name, sym := range a.symbols {
+		if !a.isGenerated(name) {
+			symbols[name] = sym
+		}
+	}
+	return symbols
+}
+
+// isGenerated reports whether any part of a qualified name is the name a
+// label takes in one expansion
+func (a *Assembler) isGenerated(name string) bool {
+	for _, part := range strings.Split(name, ".") {
+		if a.generated[part] {
+			return true
+		}
+	}
+	return false
+}
+
 // generateJSONReport creates a JSON report of the assembly
-func (a *Assembler) generateJSONReport(stats AssemblyStats) (string, error) {
+func (a *Assembler) generateJSONReport(stats AssemblyStats, outputs []OutputFile) (string, error) {
 	report := struct {
-		Symbols    map[string]Symbol `json:"symbols"`
-		Statistics AssemblyStats     `json:"statistics"`
+		Symbols       map[string]Symbol `json:"symbols"`
+		Statistics    AssemblyStats     `json:"statistics"`
+		Segments      []Segment         `json:"segments"`
+		IncludedFiles []IncludedFile    `json:"includedFiles,omitempty"`
+		BinaryFiles   []BinaryFile      `json:"binaryFiles,omitempty"`
+		OutputFiles   []OutputFile      `json:"outputFiles,omitempty"`
+		Entry         *int              `json:"entry,omitempty"`
 	}{
-		Symbols:    a.symbols,
-		Statistics: stats,
+		Symbols:       a.exportedSymbols(),
+		Statistics:    stats,
+		Segments:      a.memory.segments(),
+		IncludedFiles: a.included,
+		BinaryFiles:   a.binaryFiles,
+		OutputFiles:   outputs,
+		Entry:         a.entryAddress(),
 	}
 
 	data, err := json.MarshalIndent(report, "", "  ")
@@ -301,9 +615,27 @@ func (a *Assembler) GetCurrentAddress() int {
 	return a.currentAddr
 }
 
-// GetOutput returns the assembled binary
+// entryAddress returns the execution address given by END, or nil if
+// there is none
+func (a *Assembler) entryAddress() *int {
+	if a.entryFrom == nil {
+		return nil
+	}
+	entry := a.entry
+	return &entry
+}
+
+// logicalAddr returns the address code at the current position runs at,
+// which differs from where it is stored inside PHASE ... DEPHASE
+func (a *Assembler) logicalAddr() int {
+	return a.currentAddr + a.phaseOffset
+}
+
+// GetOutput returns the assembled binary from the lowest to the highest
+// written address, with gaps filled with zero
 func (a *Assembler) GetOutput() []byte {
-	return a.output
+	_, img := a.memory.image(0)
+	return img
 }
 
 // setOrigin sets the assembly origin point
@@ -312,33 +644,74 @@ func (a *Assembler) setOrigin(addr int) {
 	a.originSet = true
 }
 
-// recordBinaryFile records a binary file for inclusion
-func (a *Assembler) recordBinaryFile(filename string, skip, length int) {
-	binFile := BinaryFile{
+// includeBinary emits length bytes of data starting at skip and records
+// where they were placed
+func (a *Assembler) includeBinary(filename string, data []byte, skip, length int) {
+	a.binaryFiles = append(a.binaryFiles, BinaryFile{
 		Filename: filename,
 		Offset:   a.currentAddr,
 		Skip:     skip,
 		Length:   length,
+	})
+
+	for _, b := range data[skip : skip+length] {
+		a.emitByte(b)
 	}
+}
 
-	// Store the binary file information for later processing
-	a.binaryFiles = append(a.binaryFiles, binFile)
+// includeChain returns the INCLUDE directives and macro calls that led to
+// the source being read, innermost first
+func (a *Assembler) includeChain() []SourceLocation {
+	chain := make([]SourceLocation, 0, len(a.includeStack))
+	for i := len(a.includeStack) - 1; i >= 0; i-- {
+		chain = append(chain, a.includeStack[i])
+	}
+	return chain
+}
 
-	// Update current address based on file size
-	// This will be validated during actual file processing
-	if length == -1 {
-		info, err := os.Stat(filename)
-		if err == nil {
-			fileSize := int(info.Size()) - skip
-			a.currentAddr += fileSize
-		}
+// findFile locates a file named by INCLUDE or INCBIN. The directory of the
+// including file is searched first, then each include path in order, then
+// the current directory.
+func (a *Assembler) findFile(filename string, from SourceLocation, kind string) (string, error) {
+	var dirs []string
+	if filepath.IsAbs(filename) {
+		dirs = []string{""}
 	} else {
-		a.currentAddr += length
+		seen := make(map[string]bool)
+		search := append([]string{filepath.Dir(from.File)}, a.includePath...)
+		for _, dir := range append(search, ".") {
+			dir = filepath.Clean(dir)
+			if !seen[dir] {
+				seen[dir] = true
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+
+	for _, dir := range dirs {
+		path := filepath.Join(dir, filename)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			// Record each file once, when the final pass reads it
+			if a.pass == finalPass {
+				a.included = append(a.included, IncludedFile{Name: filename, Path: path, Kind: kind})
+			}
+			return path, nil
+		}
+	}
+
+	if filepath.IsAbs(filename) {
+		return "", fmt.Errorf("file not found: %s", filename)
 	}
+	return "", fmt.Errorf("file not found: %s (searched %s)", filename, strings.Join(dirs, ", "))
 }
 
-// processIncludeFile processes an included source file
-func (a *Assembler) processIncludeFile(filename string) error {
+// processIncludeFile processes a source file included from the given location
+func (a *Assembler) processIncludeFile(filename string, from SourceLocation) error {
+	filename, err := a.findFile(filename, from, "source")
+	if err != nil {
+		return err
+	}
+
 	absPath, err := filepath.Abs(filename)
 	if err != nil {
 		return err
@@ -359,18 +732,54 @@ func (a *Assembler) processIncludeFile(filename string) error {
 		return fmt.Errorf("failed to read include file %s: %v", filename, err)
 	}
 
-	// Create a new parser for this file
+	// Errors inside the file are reported with the chain of includes
+	a.includeStack = append(a.includeStack, from)
+	defer func() { a.includeStack = a.includeStack[:len(a.includeStack)-1] }()
+
+	// Create a new parser for this file; its errors go to the shared list
 	parser := NewParser(string(content), a.options.Debug)
 	parser.assembler = a
+	parser.filename = filename
+	parser.parseAll()
 
-	// Process each line
-	for !parser.isEOF() {
-		if err := parser.parseLine(); err != nil {
-			return fmt.Errorf("error in included file %s: %v", filename, err)
-		}
+	return nil
+}
+
+// runPass reads the whole source once, returning the number of lines
+// processed. Errors are collected in a.errors rather than returned.
+func (a *Assembler) runPass(pass int, filename, content string) int {
+	a.resetPass(pass)
+
+	parser := NewParser(content, a.options.Debug)
+	parser.assembler = a
+	parser.filename = filename
+
+	lines := parser.parseAll()
+	a.checkModules()
+	if a.phase != nil {
+		a.errors.Add(directiveError(a.phase.File, a.phase.Line, "PHASE without DEPHASE"))
 	}
+	return lines
+}
 
-	return nil
+// report records a warning or note from the final pass
+func (a *Assembler) report(msg AssemblerError) {
+	a.messages.Add(msg)
+}
+
+// Messages returns the warnings and notes of the last assembly, which are
+// kept even when it failed
+func (a *Assembler) Messages() []AssemblerError {
+	return a.messages.Errors()
+}
+
+// failure returns the errors collected so far, or nil if there are none
+func (a *Assembler) failure() error {
+	if !a.errors.HasErrors() {
+		return nil
+	}
+	errs := a.errors
+	return &errs
 }
 
 // Assemble processes the input file and generates output
@@ -380,36 +789,48 @@ func (a *Assembler) Assemble(filename string) (AssemblyResult, error) {
 		return AssemblyResult{}, fmt.Errorf("failed to read input file: %v", err)
 	}
 
-
-	parser := NewParser(string(content), a.options.Debug)
-	parser.assembler = a
-
-	// Process each line
-	linesProcessed := 0
-	for !parser.isEOF() {
-		if err := parser.parseLine(); err != nil {
-			return AssemblyResult{}, err
-		}
-		linesProcessed++
+	// Pass 1 sizes every statement and records where each label lands
+	a.errors = ErrorList{}
+	a.messages = ErrorList{}
+	a.runPass(firstPass, filename, string(content))
+	firstErrors := a.errors
+
+	// Pass 2 emits the code with all symbols, including forward ones, known.
+	// It runs even when pass 1 failed, since only it finds undefined symbols.
+	a.errors = ErrorList{}
+	linesProcessed := a.runPass(finalPass, filename, string(content))
+	if firstErrors.HasErrors() {
+		a.errors = mergePasses(firstErrors, a.errors)
+	}
+	if err := a.failure(); err != nil {
+		return AssemblyResult{}, err
 	}
 
-	// Resolve forward references
-	if err := a.resolveForwardRefs(); err != nil {
+	// Build the files asked for in the source; a bad range fails the build
+	outputs := a.buildOutputs()
+	if err := a.failure(); err != nil {
 		return AssemblyResult{}, err
 	}
 
 	// Generate assembly stats
 	stats := AssemblyStats{
-		BytesGenerated: len(a.output),
+		BytesGenerated: a.memory.count,
 		LinesProcessed: linesProcessed,
 		SymbolsDefined: len(a.symbols),
 	}
 
 	// Create assembly result
+	origin, binary := a.memory.image(0)
 	result := AssemblyResult{
-		Success:    true,
-		Binary:     a.output,
-		Statistics: stats,
+		Success:       true,
+		Binary:        binary,
+		Origin:        origin,
+		Segments:      a.memory.segments(),
+		SplitSegments: a.splitOutput,
+		Statistics:    stats,
+		IncludedFiles: a.included,
+		OutputFiles:   outputs,
+		Entry:         a.entryAddress(),
 	}
 
 	// Generate hex dump if enabled
@@ -417,9 +838,19 @@ func (a *Assembler) Assemble(filename string) (AssemblyResult, error) {
 		result.HexDump = a.generateHexDump()
 	}
 
+	// Generate listing if enabled
+	if a.listOutput {
+		result.Listing = a.generateListing()
+	}
+
+	// Generate symbol file if enabled
+	if a.symOutput {
+		result.SymbolFile = a.generateSymbolFile()
+	}
+
 	// Generate JSON report if enabled
 	if a.jsonOutput {
-		report, err := a.generateJSONReport(stats)
+		report, err := a.generateJSONReport(stats, outputs)
 		if err != nil {
 			return AssemblyResult{}, err
 		}
@@ -431,8 +862,15 @@ func (a *Assembler) Assemble(filename string) (AssemblyResult, error) {
 
 // WriteFiles writes all output files for the assembly result
 func (r *AssemblyResult) WriteFiles(baseFilename string) error {
-	// Always write binary output
-	if err := os.WriteFile(baseFilename+".bin", r.Binary, 0644); err != nil {
+	// Always write binary output, either as one image or per segment
+	if r.SplitSegments {
+		for _, seg := range r.Segments {
+			name := fmt.Sprintf("%s_%04X.bin", baseFilename, seg.Start)
+			if err := os.WriteFile(name, seg.Data, 0644); err != nil {
+				return fmt.Errorf("failed to write binary file: %v", err)
+			}
+		}
+	} else if err := os.WriteFile(baseFilename+".bin", r.Binary, 0644); err != nil {
 		return fmt.Errorf("failed to write binary file: %v", err)
 	}
 
@@ -443,6 +881,27 @@ func (r *AssemblyResult) WriteFiles(baseFilename string) error {
 		}
 	}
 
+	// Write listing if present
+	if r.Listing != "" {
+		if err := os.WriteFile(baseFilename+".lst", []byte(r.Listing), 0644); err != nil {
+			return fmt.Errorf("failed to write listing: %v", err)
+		}
+	}
+
+	// Write symbol file if present
+	if r.SymbolFile != "" {
+		if err := os.WriteFile(baseFilename+".sym", []byte(r.SymbolFile), 0644); err != nil {
+			return fmt.Errorf("failed to write symbol file: %v", err)
+		}
+	}
+
+	// Write the files asked for by output directives
+	for _, out := range r.OutputFiles {
+		if err := os.WriteFile(out.Path, out.Data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s file: %v", out.Format, err)
+		}
+	}
+
 	// Write JSON report if present
 	if r.JSONReport != "" {
 		if err := os.WriteFile(baseFilename+".json", []byte(r.JSONReport), 0644); err != nil {
@@ -0,0 +1,256 @@
+// file: internal/zxa_assembler/assembler_test.go
+
+package zxa_assembler
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// asmCase is a source snippet and what assembling it should give: the
+// image from the lowest written address, or the text of the first error
+type asmCase struct {
+	name    string
+	src     string
+	want    []byte
+	wantErr string
+}
+
+// writeFiles writes files, by path relative to a fresh directory, and
+// returns the directory
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// assembleFiles writes main.asm and any other files to a fresh directory
+// and assembles main.asm
+func assembleFiles(t *testing.T, opts AssemblerOptions, src string, files map[string]string) (*Assembler, AssemblyResult, error) {
+	t.Helper()
+	dir := writeFiles(t, files)
+	main := filepath.Join(dir, "main.asm")
+	if err := os.WriteFile(main, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a := NewAssembler(opts)
+	result, err := a.Assemble(main)
+	return a, result, err
+}
+
+// assembleSource assembles a single source with the default options
+func assembleSource(t *testing.T, src string) (*Assembler, AssemblyResult, error) {
+	t.Helper()
+	return assembleFiles(t, AssemblerOptions{}, src, nil)
+}
+
+// runCases assembles each case and checks its bytes or its error
+func runCases(t *testing.T, opts AssemblerOptions, cases []asmCase) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, result, err := assembleFiles(t, opts, tc.src, nil)
+			checkResult(t, result, err, tc.want, tc.wantErr)
+		})
+	}
+}
+
+// checkResult compares an assembly result with the expected bytes or error
+func checkResult(t *testing.T, result AssemblyResult, err error, want []byte, wantErr string) {
+	t.Helper()
+	if wantErr != "" {
+		if err == nil {
+			t.Fatalf("expected error containing %q, assembled % X", wantErr, result.Binary)
+		}
+		if !strings.Contains(err.Error(), wantErr) {
+			t.Fatalf("expected error containing %q, got: %v", wantErr, err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(result.Binary, want) {
+		t.Fatalf("got % X, want % X", result.Binary, want)
+	}
+}
+
+// checkSymbols compares symbol values with the expected ones
+func checkSymbols(t *testing.T, a *Assembler, want map[string]int) {
+	t.Helper()
+	for name, value := range want {
+		sym, ok := a.symbols[name]
+		if !ok {
+			t.Errorf("symbol %s not defined", name)
+			continue
+		}
+		if sym.Value != value {
+			t.Errorf("symbol %s = $%04X, want $%04X", name, sym.Value, value)
+		}
+	}
+}
+
+// errorMessages returns the text of every error of a failed assembly
+func errorMessages(err error) []string {
+	list, ok := err.(*ErrorList)
+	if !ok {
+		return []string{err.Error()}
+	}
+	var messages []string
+	for _, e := range list.Errors() {
+		messages = append(messages, e.Error())
+	}
+	return messages
+}
+
+func TestForwardReferences(t *testing.T) {
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{
+			name: "jump forward",
+			src:  " ORG $8000\n jp target\n ld a, 0\ntarget: ret\n",
+			want: []byte{0xC3, 0x05, 0x80, 0x3E, 0x00, 0xC9},
+		},
+		{
+			name: "relative jump forward",
+			src:  " ORG $8000\n jr skip\n ld a, 0\nskip: ret\n",
+			want: []byte{0x18, 0x02, 0x3E, 0x00, 0xC9},
+		},
+		{
+			name: "16-bit load of a later label",
+			src:  " ORG $8000\n ld hl, data\n ret\ndata: DEFB 1\n",
+			want: []byte{0x21, 0x04, 0x80, 0xC9, 0x01},
+		},
+		{
+			name: "data words referring forward",
+			src:  " ORG $9000\n DEFW a1, a2\na1: DEFB 1\na2: DEFB 2\n",
+			want: []byte{0x04, 0x90, 0x05, 0x90, 0x01, 0x02},
+		},
+		{
+			name: "forward EQU in an immediate",
+			src:  " ld a, value\nvalue EQU 42\n",
+			want: []byte{0x3E, 42},
+		},
+		{
+			name: "indexed displacement defined later",
+			src:  " ld a, (ix+offset)\noffset EQU 5\n",
+			want: []byte{0xDD, 0x7E, 0x05},
+		},
+		{
+			name:    "undefined symbol",
+			src:     " jp nowhere\n",
+			wantErr: "undefined symbol: nowhere",
+		},
+		{
+			name:    "duplicate label",
+			src:     "here: ld a, 1\nhere: ld a, 2\n",
+			wantErr: "here",
+		},
+	})
+}
+
+func TestForwardReferenceSymbols(t *testing.T) {
+	a, _, err := assembleSource(t, " ORG $8000\nstart: jp finish\n DEFS 10\nfinish: ret\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSymbols(t, a, map[string]int{"start": 0x8000, "finish": 0x800D})
+}
+
+func TestLocalLabels(t *testing.T) {
+	src := " ORG $8000\nfirst: jr .loop\n.loop: nop\nsecond: jr .loop\n.loop: jp first.loop\n"
+	a, result, err := assembleSource(t, src)
+	checkResult(t, result, err, []byte{0x18, 0x00, 0x00, 0x18, 0x00, 0xC3, 0x02, 0x80}, "")
+	checkSymbols(t, a, map[string]int{"first.loop": 0x8002, "second.loop": 0x8005})
+
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{name: "no preceding label", src: ".loop: nop\n", wantErr: "local label .loop has no preceding label"},
+		{name: "same local in one scope", src: "g:\n.x: nop\n.x: nop\n", wantErr: "g.x"},
+	})
+}
+
+func TestAnonymousLabels(t *testing.T) {
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{
+			name: "back and forward",
+			src:  " ORG $8000\n1: jr 1f\n nop\n1: jr 1b\n",
+			want: []byte{0x18, 0x01, 0x00, 0x18, 0xFE},
+		},
+		{
+			name: "nearest of several",
+			src:  " ORG $8000\n1: nop\n1: nop\n jp 1b\n",
+			want: []byte{0x00, 0x00, 0xC3, 0x01, 0x80},
+		},
+		{
+			name: "different numbers",
+			src:  " ORG $8000\n1: nop\n2: nop\n jp 1b\n jp 2b\n",
+			want: []byte{0x00, 0x00, 0xC3, 0x00, 0x80, 0xC3, 0x01, 0x80},
+		},
+		{
+			name: "label zero",
+			src:  " ORG $8000\n0: nop\n jr 0b\n jr 0f\n0: DEFB 0b101\n",
+			want: []byte{0x00, 0x18, 0xFD, 0x18, 0x00, 0x05},
+		},
+		{name: "no label behind", src: " jr 1b\n", wantErr: "no anonymous label 1: before this line"},
+		{name: "no label ahead", src: "1: jr 1f\n", wantErr: "no anonymous label 1: after this line"},
+	})
+}
+
+func TestSymbolFile(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.asm": " ORG $8000\nstart: nop\n.loop: jr .loop\nSIZE EQU 3\n",
+	})
+	a := NewAssembler(AssemblerOptions{})
+	a.SetSymbolOutput(true)
+	result, err := a.Assemble(filepath.Join(dir, "main.asm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "SIZE\tEQU 00003H\nstart\tEQU 08000H\nstart.loop\tEQU 08001H\n"
+	if result.SymbolFile != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", result.SymbolFile, want)
+	}
+}
+
+func TestSymbolFileLeavesOutExpansionLabels(t *testing.T) {
+	src := ` MACRO wait n
+ LOCAL again
+ ld b, n
+again: djnz again
+.done: ret
+ ENDM
+ ORG $8000
+start: wait 1
+ REPT 2
+loop: jr loop
+ ENDR
+.next: jr .next
+`
+	dir := writeFiles(t, map[string]string{"main.asm": src})
+	a := NewAssembler(AssemblerOptions{})
+	a.SetSymbolOutput(true)
+	a.SetJSONOutput(true)
+	result, err := a.Assemble(filepath.Join(dir, "main.asm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Labels of the expansions leave .next in the scope of start
+	if want := "start\tEQU 08000H\nstart.next\tEQU 08009H\n"; result.SymbolFile != want {
+		t.Errorf("got:\n%s\nwant:\n%s", result.SymbolFile, want)
+	}
+	if strings.Contains(result.JSONReport, "__") {
+		t.Errorf("report lists expansion labels:\n%s", result.JSONReport)
+	}
+	checkSymbols(t, a, map[string]int{"again__1": 0x8002, "start.done__1": 0x8004, "loop__2": 0x8005, "loop__3": 0x8007})
+}
@@ -1,36 +1,67 @@
 package zxa_assembler
 
+import "fmt"
+
+// Operand encodings used to generate the instruction tables. The index of
+// each name is the value it contributes to the opcode.
+var (
+	// 8-bit registers, r field (bits 5-3 or 2-0)
+	regs8 = [8]string{"B", "C", "D", "E", "H", "L", "(HL)", "A"}
+
+	// Register pairs for loads and 16-bit arithmetic, dd/ss field (bits 5-4)
+	regPairs = [4]string{"BC", "DE", "HL", "SP"}
+
+	// Register pairs for PUSH and POP, qq field (bits 5-4)
+	stackPairs = [4]string{"BC", "DE", "HL", "AF"}
+
+	// Condition codes, cc field (bits 5-3); JR only takes the first four
+	conditions = [8]string{"NZ", "Z", "NC", "C", "PO", "PE", "P", "M"}
+
+	// 8-bit arithmetic and logic operations, alu field (bits 5-3), written
+	// with the accumulator operand as the Zilog manual spells each one
+	aluOps = [8]string{"ADD A,", "ADC A,", "SUB ", "SBC A,", "AND ", "XOR ", "OR ", "CP "}
+)
+
 // initBaseInstructions adds all non-prefixed instructions to the instruction map
 func (m InstructionMap) initBaseInstructions() {
 	// 8-bit load group
-	m["LD A,A"] = Instruction{0x7F, 0x00, Register, 1, 4, false}
-	m["LD A,B"] = Instruction{0x78, 0x00, Register, 1, 4, false}
-	m["LD A,C"] = Instruction{0x79, 0x00, Register, 1, 4, false}
-	m["LD A,D"] = Instruction{0x7A, 0x00, Register, 1, 4, false}
-	m["LD A,E"] = Instruction{0x7B, 0x00, Register, 1, 4, false}
-	m["LD A,H"] = Instruction{0x7C, 0x00, Register, 1, 4, false}
-	m["LD A,L"] = Instruction{0x7D, 0x00, Register, 1, 4, false}
-	m["LD A,(HL)"] = Instruction{0x7E, 0x00, RegisterIndirect, 1, 7, false}
-	m["LD A,n"] = Instruction{0x3E, 0x00, Immediate, 2, 7, false}
+	for dst, d := range regs8 {
+		for src, s := range regs8 {
+			// LD (HL),(HL) is the HALT opcode
+			if dst == 6 && src == 6 {
+				continue
+			}
+			opcode := byte(0x40 | dst<<3 | src)
+			if dst == 6 || src == 6 {
+				m["LD "+d+","+s] = Instruction{opcode, 0x00, RegisterIndirect, 1, 7, false}
+			} else {
+				m["LD "+d+","+s] = Instruction{opcode, 0x00, Register, 1, 4, false}
+			}
+		}
+		if dst == 6 {
+			m["LD (HL),n"] = Instruction{0x36, 0x00, Immediate, 2, 10, false}
+		} else {
+			m["LD "+d+",n"] = Instruction{byte(0x06 | dst<<3), 0x00, Immediate, 2, 7, false}
+		}
+	}
 	m["LD A,(BC)"] = Instruction{0x0A, 0x00, RegisterIndirect, 1, 7, false}
 	m["LD A,(DE)"] = Instruction{0x1A, 0x00, RegisterIndirect, 1, 7, false}
 	m["LD A,(nn)"] = Instruction{0x3A, 0x00, Extended, 3, 13, false}
-
-	m["LD B,A"] = Instruction{0x47, 0x00, Register, 1, 4, false}
-	m["LD B,B"] = Instruction{0x40, 0x00, Register, 1, 4, false}
-	m["LD B,C"] = Instruction{0x41, 0x00, Register, 1, 4, false}
-	m["LD B,D"] = Instruction{0x42, 0x00, Register, 1, 4, false}
-	m["LD B,E"] = Instruction{0x43, 0x00, Register, 1, 4, false}
-	m["LD B,H"] = Instruction{0x44, 0x00, Register, 1, 4, false}
-	m["LD B,L"] = Instruction{0x45, 0x00, Register, 1, 4, false}
-	m["LD B,n"] = Instruction{0x06, 0x00, Immediate, 2, 7, false}
+	m["LD (BC),A"] = Instruction{0x02, 0x00, RegisterIndirect, 1, 7, false}
+	m["LD (DE),A"] = Instruction{0x12, 0x00, RegisterIndirect, 1, 7, false}
+	m["LD (nn),A"] = Instruction{0x32, 0x00, Extended, 3, 13, false}
 
 	// 16-bit load group
-	m["LD BC,nn"] = Instruction{0x01, 0x00, ImmediateExt, 3, 10, false}
-	m["LD DE,nn"] = Instruction{0x11, 0x00, ImmediateExt, 3, 10, false}
-	m["LD HL,nn"] = Instruction{0x21, 0x00, ImmediateExt, 3, 10, false}
-	m["LD SP,nn"] = Instruction{0x31, 0x00, ImmediateExt, 3, 10, false}
+	for p, rr := range regPairs {
+		m["LD "+rr+",nn"] = Instruction{byte(0x01 | p<<4), 0x00, ImmediateExt, 3, 10, false}
+	}
+	m["LD HL,(nn)"] = Instruction{0x2A, 0x00, Extended, 3, 16, false}
+	m["LD (nn),HL"] = Instruction{0x22, 0x00, Extended, 3, 16, false}
 	m["LD SP,HL"] = Instruction{0xF9, 0x00, Register, 1, 6, false}
+	for p, qq := range stackPairs {
+		m["PUSH "+qq] = Instruction{byte(0xC5 | p<<4), 0x00, RegisterPair, 1, 11, false}
+		m["POP "+qq] = Instruction{byte(0xC1 | p<<4), 0x00, RegisterPair, 1, 10, false}
+	}
 
 	// Exchange group
 	m["EX DE,HL"] = Instruction{0xEB, 0x00, Implied, 1, 4, false}
@@ -39,55 +70,75 @@ func (m InstructionMap) initBaseInstructions() {
 	m["EX (SP),HL"] = Instruction{0xE3, 0x00, RegisterIndirect, 1, 19, false}
 
 	// 8-bit arithmetic and logical group
-	m["ADD A,A"] = Instruction{0x87, 0x00, Register, 1, 4, false}
-	m["ADD A,B"] = Instruction{0x80, 0x00, Register, 1, 4, false}
-	m["ADD A,n"] = Instruction{0xC6, 0x00, Immediate, 2, 7, false}
-	m["ADC A,A"] = Instruction{0x8F, 0x00, Register, 1, 4, false}
-	m["SUB A"] = Instruction{0x97, 0x00, Register, 1, 4, false}
-	m["SUB n"] = Instruction{0xD6, 0x00, Immediate, 2, 7, false}
-	m["AND A"] = Instruction{0xA7, 0x00, Register, 1, 4, false}
-	m["AND n"] = Instruction{0xE6, 0x00, Immediate, 2, 7, false}
-	m["OR A"] = Instruction{0xB7, 0x00, Register, 1, 4, false}
-	m["OR n"] = Instruction{0xF6, 0x00, Immediate, 2, 7, false}
-	m["XOR A"] = Instruction{0xAF, 0x00, Register, 1, 4, false}
-	m["XOR n"] = Instruction{0xEE, 0x00, Immediate, 2, 7, false}
-	m["CP A"] = Instruction{0xBF, 0x00, Register, 1, 4, false}
-	m["CP n"] = Instruction{0xFE, 0x00, Immediate, 2, 7, false}
-	m["INC A"] = Instruction{0x3C, 0x00, Register, 1, 4, false}
-	m["DEC A"] = Instruction{0x3D, 0x00, Register, 1, 4, false}
+	for op, alu := range aluOps {
+		for r, reg := range regs8 {
+			if r == 6 {
+				m[alu+reg] = Instruction{byte(0x80 | op<<3 | r), 0x00, RegisterIndirect, 1, 7, false}
+			} else {
+				m[alu+reg] = Instruction{byte(0x80 | op<<3 | r), 0x00, Register, 1, 4, false}
+			}
+		}
+		m[alu+"n"] = Instruction{byte(0xC6 | op<<3), 0x00, Immediate, 2, 7, false}
+	}
+	for r, reg := range regs8 {
+		if r == 6 {
+			m["INC (HL)"] = Instruction{0x34, 0x00, RegisterIndirect, 1, 11, false}
+			m["DEC (HL)"] = Instruction{0x35, 0x00, RegisterIndirect, 1, 11, false}
+		} else {
+			m["INC "+reg] = Instruction{byte(0x04 | r<<3), 0x00, Register, 1, 4, false}
+			m["DEC "+reg] = Instruction{byte(0x05 | r<<3), 0x00, Register, 1, 4, false}
+		}
+	}
+
+	// General-purpose arithmetic and CPU control group
+	m["DAA"] = Instruction{0x27, 0x00, Implied, 1, 4, false}
+	m["CPL"] = Instruction{0x2F, 0x00, Implied, 1, 4, false}
+	m["CCF"] = Instruction{0x3F, 0x00, Implied, 1, 4, false}
+	m["SCF"] = Instruction{0x37, 0x00, Implied, 1, 4, false}
+	m["NOP"] = Instruction{0x00, 0x00, Implied, 1, 4, false}
+	m["HALT"] = Instruction{0x76, 0x00, Implied, 1, 4, false}
+	m["DI"] = Instruction{0xF3, 0x00, Implied, 1, 4, false}
+	m["EI"] = Instruction{0xFB, 0x00, Implied, 1, 4, false}
+
+	// 16-bit arithmetic group
+	for p, ss := range regPairs {
+		m["ADD HL,"+ss] = Instruction{byte(0x09 | p<<4), 0x00, RegisterPair, 1, 11, false}
+		m["INC "+ss] = Instruction{byte(0x03 | p<<4), 0x00, RegisterPair, 1, 6, false}
+		m["DEC "+ss] = Instruction{byte(0x0B | p<<4), 0x00, RegisterPair, 1, 6, false}
+	}
+
+	// Rotate and shift group
+	m["RLCA"] = Instruction{0x07, 0x00, Implied, 1, 4, false}
+	m["RRCA"] = Instruction{0x0F, 0x00, Implied, 1, 4, false}
+	m["RLA"] = Instruction{0x17, 0x00, Implied, 1, 4, false}
+	m["RRA"] = Instruction{0x1F, 0x00, Implied, 1, 4, false}
 
 	// Jump group
 	m["JP nn"] = Instruction{0xC3, 0x00, Extended, 3, 10, false}
-	m["JP NZ,nn"] = Instruction{0xC2, 0x00, Extended, 3, 10, true}
-	m["JP Z,nn"] = Instruction{0xCA, 0x00, Extended, 3, 10, true}
-	m["JP NC,nn"] = Instruction{0xD2, 0x00, Extended, 3, 10, true}
-	m["JP C,nn"] = Instruction{0xDA, 0x00, Extended, 3, 10, true}
+	m["JP (HL)"] = Instruction{0xE9, 0x00, RegisterIndirect, 1, 4, false}
 	m["JR e"] = Instruction{0x18, 0x00, Relative, 2, 12, false}
-	m["JR NZ,e"] = Instruction{0x20, 0x00, Relative, 2, 12, true}
-	m["JR Z,e"] = Instruction{0x28, 0x00, Relative, 2, 12, true}
-	m["JR NC,e"] = Instruction{0x30, 0x00, Relative, 2, 12, true}
-	m["JR C,e"] = Instruction{0x38, 0x00, Relative, 2, 12, true}
 	m["DJNZ e"] = Instruction{0x10, 0x00, Relative, 2, 13, false}
+	for cc, cond := range conditions {
+		m["JP "+cond+",nn"] = Instruction{byte(0xC2 | cc<<3), 0x00, Extended, 3, 10, true}
+		if cc < 4 {
+			m["JR "+cond+",e"] = Instruction{byte(0x20 | cc<<3), 0x00, Relative, 2, 12, true}
+		}
+	}
 
 	// Call and return group
 	m["CALL nn"] = Instruction{0xCD, 0x00, Extended, 3, 17, false}
-	m["CALL NZ,nn"] = Instruction{0xC4, 0x00, Extended, 3, 17, true}
-	m["CALL Z,nn"] = Instruction{0xCC, 0x00, Extended, 3, 17, true}
-	m["CALL NC,nn"] = Instruction{0xD4, 0x00, Extended, 3, 17, true}
-	m["CALL C,nn"] = Instruction{0xDC, 0x00, Extended, 3, 17, true}
 	m["RET"] = Instruction{0xC9, 0x00, Implied, 1, 10, false}
-	m["RET NZ"] = Instruction{0xC0, 0x00, Implied, 1, 11, true}
-	m["RET Z"] = Instruction{0xC8, 0x00, Implied, 1, 11, true}
-	m["RET NC"] = Instruction{0xD0, 0x00, Implied, 1, 11, true}
-	m["RET C"] = Instruction{0xD8, 0x00, Implied, 1, 11, true}
-
-	// RST group
-	m["RST 00H"] = Instruction{0xC7, 0x00, Implied, 1, 11, false}
-	m["RST 08H"] = Instruction{0xCF, 0x00, Implied, 1, 11, false}
-	m["RST 10H"] = Instruction{0xD7, 0x00, Implied, 1, 11, false}
-	m["RST 18H"] = Instruction{0xDF, 0x00, Implied, 1, 11, false}
-	m["RST 20H"] = Instruction{0xE7, 0x00, Implied, 1, 11, false}
-	m["RST 28H"] = Instruction{0xEF, 0x00, Implied, 1, 11, false}
-	m["RST 30H"] = Instruction{0xF7, 0x00, Implied, 1, 11, false}
-	m["RST 38H"] = Instruction{0xFF, 0x00, Implied, 1, 11, false}
-}
\ No newline at end of file
+	for cc, cond := range conditions {
+		m["CALL "+cond+",nn"] = Instruction{byte(0xC4 | cc<<3), 0x00, Extended, 3, 17, true}
+		m["RET "+cond] = Instruction{byte(0xC0 | cc<<3), 0x00, Implied, 1, 11, true}
+	}
+
+	// RST group, keyed by the restart address in decimal
+	for p := 0; p < 8; p++ {
+		m[fmt.Sprintf("RST %d", p<<3)] = Instruction{byte(0xC7 | p<<3), 0x00, Implied, 1, 11, false}
+	}
+
+	// Input and output group
+	m["IN A,(n)"] = Instruction{0xDB, 0x00, Immediate, 2, 11, false}
+	m["OUT (n),A"] = Instruction{0xD3, 0x00, Immediate, 2, 11, false}
+}
@@ -0,0 +1,130 @@
+// file: internal/zxa_assembler/base_instructions_test.go
+
+package zxa_assembler
+
+import (
+	"bytes"
+	"testing"
+)
+
+// instructionCase is one instruction and the bytes it assembles to
+type instructionCase struct {
+	src  string
+	want []byte
+}
+
+// runInstructions assembles each instruction on its own at address 0
+func runInstructions(t *testing.T, opts AssemblerOptions, cases []instructionCase) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.src, func(t *testing.T) {
+			_, result, err := assembleFiles(t, opts, " "+tc.src+"\n", nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(result.Binary, tc.want) {
+				t.Fatalf("got % X, want % X", result.Binary, tc.want)
+			}
+		})
+	}
+}
+
+func TestDocumentedInstructions(t *testing.T) {
+	runInstructions(t, AssemblerOptions{}, []instructionCase{
+		// Loads
+		{"ld a, b", []byte{0x78}},
+		{"ld (hl), e", []byte{0x73}},
+		{"ld b, 7", []byte{0x06, 0x07}},
+		{"ld (hl), 7", []byte{0x36, 0x07}},
+		{"ld a, (bc)", []byte{0x0A}},
+		{"ld (de), a", []byte{0x12}},
+		{"ld a, ($1234)", []byte{0x3A, 0x34, 0x12}},
+		{"ld ($1234), a", []byte{0x32, 0x34, 0x12}},
+		{"ld hl, ($1234)", []byte{0x2A, 0x34, 0x12}},
+		{"ld ($1234), de", []byte{0xED, 0x53, 0x34, 0x12}},
+		{"ld sp, hl", []byte{0xF9}},
+		{"ld bc, $1234", []byte{0x01, 0x34, 0x12}},
+		{"ld a, i", []byte{0xED, 0x57}},
+		{"ld r, a", []byte{0xED, 0x4F}},
+		{"ld ix, $1234", []byte{0xDD, 0x21, 0x34, 0x12}},
+		{"ld (iy-2), c", []byte{0xFD, 0x71, 0xFE}},
+		{"ld (ix+3), $55", []byte{0xDD, 0x36, 0x03, 0x55}},
+
+		// Arithmetic and logic
+		{"add a, c", []byte{0x81}},
+		{"adc a, 1", []byte{0xCE, 0x01}},
+		{"sub (hl)", []byte{0x96}},
+		{"and $0F", []byte{0xE6, 0x0F}},
+		{"xor a", []byte{0xAF}},
+		{"cp (ix+1)", []byte{0xDD, 0xBE, 0x01}},
+		{"add hl, de", []byte{0x19}},
+		{"adc hl, sp", []byte{0xED, 0x7A}},
+		{"sbc hl, bc", []byte{0xED, 0x42}},
+		{"add ix, bc", []byte{0xDD, 0x09}},
+		{"inc a", []byte{0x3C}},
+		{"dec (hl)", []byte{0x35}},
+		{"inc de", []byte{0x13}},
+		{"dec iy", []byte{0xFD, 0x2B}},
+
+		// Stack, exchange and control
+		{"push af", []byte{0xF5}},
+		{"pop ix", []byte{0xDD, 0xE1}},
+		{"ex de, hl", []byte{0xEB}},
+		{"ex af, af'", []byte{0x08}},
+		{"ex (sp), hl", []byte{0xE3}},
+		{"exx", []byte{0xD9}},
+		{"di", []byte{0xF3}},
+		{"im 1", []byte{0xED, 0x56}},
+		{"halt", []byte{0x76}},
+
+		// Jumps and calls
+		{"jp $1234", []byte{0xC3, 0x34, 0x12}},
+		{"jp nz, $1234", []byte{0xC2, 0x34, 0x12}},
+		{"jp (hl)", []byte{0xE9}},
+		{"call m, $1234", []byte{0xFC, 0x34, 0x12}},
+		{"ret c", []byte{0xD8}},
+		{"rst $38", []byte{0xFF}},
+		{"djnz $", []byte{0x10, 0xFE}},
+		{"jr nc, $", []byte{0x30, 0xFE}},
+
+		// Bit operations, rotates and shifts
+		{"bit 7, a", []byte{0xCB, 0x7F}},
+		{"set 0, (hl)", []byte{0xCB, 0xC6}},
+		{"res 3, (iy+4)", []byte{0xFD, 0xCB, 0x04, 0x9E}},
+		{"rlc b", []byte{0xCB, 0x00}},
+		{"srl a", []byte{0xCB, 0x3F}},
+		{"rla", []byte{0x17}},
+
+		// Block transfer and I/O
+		{"ldir", []byte{0xED, 0xB0}},
+		{"cpdr", []byte{0xED, 0xB9}},
+		{"out ($FE), a", []byte{0xD3, 0xFE}},
+		{"in a, ($FE)", []byte{0xDB, 0xFE}},
+		{"in d, (c)", []byte{0xED, 0x50}},
+		{"out (c), e", []byte{0xED, 0x59}},
+		{"neg", []byte{0xED, 0x44}},
+		{"reti", []byte{0xED, 0x4D}},
+	})
+}
+
+func TestInstructionErrors(t *testing.T) {
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{name: "unknown mnemonic", src: " frob a\n", wantErr: "frob"},
+		{name: "invalid operands", src: " ld (bc), b\n", wantErr: "unknown instruction: LD (bc),b"},
+		{name: "relative jump too far", src: " jr $+200\n", wantErr: "out of range"},
+		{name: "displacement too large", src: " ld a, (ix+200)\n", wantErr: "displacement"},
+		{name: "bit number", src: " bit 8, a\n", wantErr: "bit number must be between 0 and 7, got: 8"},
+	})
+}
+
+func TestOpcodeValueForwardReferences(t *testing.T) {
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{name: "BIT", src: " bit FLAG, a\n set FLAG, (ix+2)\nFLAG EQU 3\n", want: []byte{0xCB, 0x5F, 0xDD, 0xCB, 0x02, 0xDE}},
+		{name: "RST", src: " rst VEC\n nop\nVEC EQU $28\n", want: []byte{0xEF, 0x00}},
+		{name: "IM", src: " im MODE\n nop\nMODE EQU 2\n", want: []byte{0xED, 0x5E, 0x00}},
+		{name: "bit number out of range", src: " bit FLAG, a\nFLAG EQU 9\n", wantErr: "bit number must be between 0 and 7, got: 9"},
+		{name: "restart address", src: " rst VEC\nVEC EQU 3\n", wantErr: "restart address must be a multiple of 8"},
+		{name: "interrupt mode", src: " im MODE\nMODE EQU 3\n", wantErr: "interrupt mode must be 0, 1 or 2, got: 3"},
+		{name: "undefined bit number", src: " bit FLAG, a\n", wantErr: "undefined symbol: FLAG"},
+	})
+}
@@ -18,6 +18,12 @@ const (
 	ErrRange                   // Range errors (jumps too far, etc)
 	ErrInternal                // Internal assembler errors
 	ErrIndexed                 // Indexed addressing errors
+	ErrAssertion               // ASSERT conditions that do not hold
+	ErrUser                    // Errors raised by the ERROR directive
+
+	// Messages that are reported without failing the assembly
+	Warning // WARNING directives
+	Note    // DISPLAY directives
 )
 
 // String returns the string representation of an error category
@@ -37,6 +43,16 @@ func (e ErrorCategory) String() string {
 		return "range error"
 	case ErrInternal:
 		return "internal error"
+	case ErrIndexed:
+		return "indexed addressing error"
+	case ErrAssertion:
+		return "assertion failed"
+	case ErrUser:
+		return "error"
+	case Warning:
+		return "warning"
+	case Note:
+		return "note"
 	default:
 		return "unknown error"
 	}
@@ -51,13 +67,26 @@ var (
 	ErrInvalidIndexRegister = "invalid index register, expected IX or IY"
 )
 
+// SourceLocation identifies a line in a source file
+type SourceLocation struct {
+	File  string
+	Line  int
+	Macro string // Macro called at this line, empty for an INCLUDE
+}
+
+// String formats the location as file:line
+func (l SourceLocation) String() string {
+	return fmt.Sprintf("%s:%d", l.File, l.Line)
+}
+
 // AssemblerError represents a detailed error with category and location
 type AssemblerError struct {
-	Category ErrorCategory
-	Message  string
-	File     string
-	Line     int
-	Column   int // Column where error was detected
+	Category     ErrorCategory
+	Message      string
+	File         string
+	Line         int
+	Column       int              // Column where error was detected
+	IncludedFrom []SourceLocation // INCLUDEs and macro calls that led to File, innermost first
 }
 
 // Error creation helper functions for indexed addressing
@@ -72,15 +101,51 @@ func indexedAddressError(file string, line int, msg string, args ...interface{})
 
 // Error implements the error interface for AssemblerError
 func (e AssemblerError) Error() string {
-	// Get base filename for cleaner output
-	filename := filepath.Base(e.File)
+	// Report the path the file was opened with, so includes found in
+	// different directories can be told apart
+	filename := filepath.Clean(e.File)
 
+	var msg string
 	if e.Column > 0 {
-		return fmt.Sprintf("%s:%d:%d: %s: %s",
+		msg = fmt.Sprintf("%s:%d:%d: %s: %s",
 			filename, e.Line, e.Column, e.Category, e.Message)
+	} else {
+		msg = fmt.Sprintf("%s:%d: %s: %s",
+			filename, e.Line, e.Category, e.Message)
+	}
+
+	// Show how the file was reached, e.g. "included from a.asm:12, from main.asm:3"
+	included := false
+	for i := 0; i < len(e.IncludedFrom); i++ {
+		from := e.IncludedFrom[i]
+		if i == 0 {
+			msg += " ("
+		} else {
+			msg += ", "
+		}
+		switch {
+		case from.Macro != "":
+			msg += fmt.Sprintf("in macro %s called at %s", from.Macro, from)
+			// A recursive macro repeats the same call; show it once
+			repeats := 1
+			for i+1 < len(e.IncludedFrom) && e.IncludedFrom[i+1] == from {
+				i++
+				repeats++
+			}
+			if repeats > 1 {
+				msg += fmt.Sprintf(" (%d times)", repeats)
+			}
+		case included:
+			msg += fmt.Sprintf("from %s", from)
+		default:
+			msg += fmt.Sprintf("included from %s", from)
+			included = true
+		}
+	}
+	if len(e.IncludedFrom) > 0 {
+		msg += ")"
 	}
-	return fmt.Sprintf("%s:%d: %s: %s",
-		filename, e.Line, e.Category, e.Message)
+	return msg
 }
 
 // ErrorList represents a collection of assembler errors
@@ -117,6 +182,49 @@ func (l *ErrorList) Error() string {
 		l.errors[0].Error(), len(l.errors)-1)
 }
 
+// mergePasses combines the errors of a failed first pass with those of the
+// final pass. A line that failed in pass 1 keeps its pass 1 error, since
+// pass 2 only repeats it or reports what follows from it; the lines pass 1
+// accepted add the errors only pass 2 finds, such as undefined symbols.
+// Both lists are in source order, and so is the result.
+func mergePasses(first, final ErrorList) ErrorList {
+	failed := make(map[string]bool)
+	for _, e := range first.errors {
+		failed[e.location()] = true
+	}
+
+	var merged ErrorList
+	pending := first.errors
+	for _, e := range final.errors {
+		loc := e.location()
+		if !failed[loc] {
+			merged.Add(e)
+			continue
+		}
+		// Report the pass 1 errors up to and including those of this line
+		last := -1
+		for i, prev := range pending {
+			if prev.location() == loc {
+				last = i
+			}
+		}
+		merged.errors = append(merged.errors, pending[:last+1]...)
+		pending = pending[last+1:]
+	}
+	merged.errors = append(merged.errors, pending...)
+	return merged
+}
+
+// location identifies the line an error was found on, including the
+// INCLUDEs that led to it
+func (e AssemblerError) location() string {
+	loc := fmt.Sprintf("%s:%d", e.File, e.Line)
+	for _, from := range e.IncludedFrom {
+		loc += fmt.Sprintf("<%s", from)
+	}
+	return loc
+}
+
 // Error creation helper functions
 func syntaxError(file string, line, col int, msg string, args ...interface{}) AssemblerError {
 	return AssemblerError{
@@ -146,11 +254,12 @@ func valueError(file string, line int, msg string, args ...interface{}) Assemble
 	}
 }
 
-func fileError(file string, msg string, args ...interface{}) AssemblerError {
+func fileError(file string, line int, msg string, args ...interface{}) AssemblerError {
 	return AssemblerError{
 		Category: ErrFile,
 		Message:  fmt.Sprintf(msg, args...),
 		File:     file,
+		Line:     line,
 	}
 }
 
@@ -172,6 +281,26 @@ func rangeError(file string, line int, msg string, args ...interface{}) Assemble
 	}
 }
 
+func assertionError(file string, line int, msg string, args ...interface{}) AssemblerError {
+	return AssemblerError{
+		Category: ErrAssertion,
+		Message:  fmt.Sprintf(msg, args...),
+		File:     file,
+		Line:     line,
+	}
+}
+
+// message creates a diagnostic of the given category for the ERROR,
+// WARNING and DISPLAY directives
+func message(category ErrorCategory, file string, line int, text string) AssemblerError {
+	return AssemblerError{
+		Category: category,
+		Message:  text,
+		File:     file,
+		Line:     line,
+	}
+}
+
 func internalError(msg string, args ...interface{}) AssemblerError {
 	return AssemblerError{
 		Category: ErrInternal,
@@ -0,0 +1,164 @@
+// file: internal/zxa_assembler/errors_test.go
+
+package zxa_assembler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAllErrorsReported(t *testing.T) {
+	src := " ld a, 300\n nop\n jp nowhere\n frob\n ld b, 1\n DEFB 1/0\n"
+	_, _, err := assembleSource(t, src)
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	list, ok := err.(*ErrorList)
+	if !ok {
+		t.Fatalf("expected an *ErrorList, got %T", err)
+	}
+
+	// Errors of both passes are reported in source order, each once
+	want := []struct {
+		line     int
+		category ErrorCategory
+	}{
+		{1, ErrValue},
+		{3, ErrSymbol},
+		{4, ErrSyntax},
+		{6, ErrValue},
+	}
+	errs := list.Errors()
+	if len(errs) != len(want) {
+		t.Fatalf("got %d errors, want %d: %v", len(errs), len(want), errorMessages(err))
+	}
+	for i, w := range want {
+		if errs[i].Line != w.line || errs[i].Category != w.category {
+			t.Errorf("error %d: got line %d %s, want line %d %s", i, errs[i].Line, errs[i].Category, w.line, w.category)
+		}
+	}
+}
+
+func TestFinalPassErrors(t *testing.T) {
+	_, _, err := assembleSource(t, " jp nowhere\n nop\n call missing\n")
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	messages := errorMessages(err)
+	if len(messages) != 2 {
+		t.Fatalf("got %d errors, want 2: %v", len(messages), messages)
+	}
+	for i, name := range []string{"nowhere", "missing"} {
+		if !strings.Contains(messages[i], "undefined symbol: "+name) {
+			t.Errorf("error %d: %s", i, messages[i])
+		}
+	}
+}
+
+func TestFailedOperandKeepsLength(t *testing.T) {
+	// Each operand is only found out of range in pass 2; the label after it
+	// must not move
+	for _, src := range []string{
+		" ORG $8000\n jr far\nlab: nop\nfar EQU $9000\n",
+		" ORG $8000\n ld a, big\nlab: nop\nbig EQU 300\n",
+		" ORG $8000\n ld a, (ix+off)\nlab: nop\noff EQU 200\n",
+		" ORG $8000\n ld (ix+off), 1\nlab: nop\noff EQU 200\n",
+	} {
+		_, _, err := assembleSource(t, src)
+		if err == nil {
+			t.Fatalf("%q: expected an error", src)
+		}
+		if messages := errorMessages(err); len(messages) != 1 {
+			t.Errorf("%q: got %d errors, want 1: %v", src, len(messages), messages)
+		}
+	}
+}
+
+func TestOneErrorOneDiagnostic(t *testing.T) {
+	// A statement that fails in pass 2 keeps the size pass 1 gave it, so
+	// the labels after it report no phase errors
+	tests := []struct {
+		src  string
+		want int // Errors
+		end  int // Address reached, as in pass 1
+	}{
+		{" ORG $8000\n call nowhere\nlab1: nop\nlab2: nop\n", 1, 0x8005},
+		{" ORG $8000\n DEFW nowhere, 1\nlab1: nop\nlab2: nop\n", 1, 0x8006},
+		{" ORG $8000\n REPT 3\n DEFB 1, nowhere\n ENDR\nlab1: nop\n1: nop\n jr 1b\n", 1, 0x800A},
+		{" ORG $8000\n MACRO m\n DEFW nowhere\n ENDM\n m\nlab1: nop\n m\nlab2: nop\n", 2, 0x8006},
+	}
+	for _, tc := range tests {
+		a, _, err := assembleSource(t, tc.src)
+		if err == nil {
+			t.Fatalf("%q: expected an error", tc.src)
+		}
+		if a.currentAddr != tc.end {
+			t.Errorf("%q: ended at $%04X, want $%04X", tc.src, a.currentAddr, tc.end)
+		}
+		messages := errorMessages(err)
+		if len(messages) != tc.want {
+			t.Errorf("%q: got %d errors, want %d: %v", tc.src, len(messages), tc.want, messages)
+		}
+		for _, msg := range messages {
+			if !strings.Contains(msg, "undefined symbol: nowhere") {
+				t.Errorf("%q: unexpected error: %s", tc.src, msg)
+			}
+		}
+	}
+}
+
+func TestPassOneErrorReportedOnce(t *testing.T) {
+	// The duplicate label lands elsewhere in pass 2 too, which must not add
+	// a phase error to the one pass 1 reported
+	_, _, err := assembleSource(t, "x1: nop\nx1: nop\n jp nowhere\n")
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	messages := errorMessages(err)
+	if len(messages) != 2 {
+		t.Fatalf("got %d errors, want 2: %v", len(messages), messages)
+	}
+	for i, want := range []string{"duplicate symbol: x1", "undefined symbol: nowhere"} {
+		if !strings.Contains(messages[i], want) {
+			t.Errorf("error %d: %s", i, messages[i])
+		}
+	}
+}
+
+func TestErrorFormat(t *testing.T) {
+	tests := []struct {
+		err  AssemblerError
+		want string
+	}{
+		{syntaxError("a.asm", 3, 5, "bad"), "a.asm:3:5: syntax error: bad"},
+		{symbolError("a.asm", 4, "undefined symbol: %s", "x"), "a.asm:4: symbol error: undefined symbol: x"},
+		{rangeError("dir/../b.asm", 1, "too far"), "b.asm:1: range error: too far"},
+	}
+	for _, tc := range tests {
+		if got := tc.err.Error(); got != tc.want {
+			t.Errorf("got %q, want %q", got, tc.want)
+		}
+	}
+}
+
+func TestIncludeChainInErrors(t *testing.T) {
+	files := map[string]string{
+		"outer.inc": " nop\n INCLUDE \"inner.inc\"\n",
+		"inner.inc": " nop\n ld a, 999\n",
+	}
+	_, _, err := assembleFiles(t, AssemblerOptions{}, " ORG $8000\n INCLUDE \"outer.inc\"\n", files)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	list := err.(*ErrorList)
+	e := list.Errors()[0]
+	if !strings.HasSuffix(e.File, "inner.inc") || e.Line != 2 {
+		t.Fatalf("error located at %s:%d, want inner.inc:2", e.File, e.Line)
+	}
+	msg := e.Error()
+	for _, want := range []string{"inner.inc:2", "included from ", "outer.inc:2", "from ", "main.asm:2"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("%q does not mention %q", msg, want)
+		}
+	}
+}
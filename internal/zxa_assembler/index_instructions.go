@@ -4,111 +4,75 @@ package zxa_assembler
 
 import "fmt"
 
+// indexRegs maps each index register to its instruction prefix
+var indexRegs = []struct {
+	name   string
+	prefix byte
+}{
+	{"IX", 0xDD},
+	{"IY", 0xFD},
+}
+
 // initIndexInstructions adds all DD/FD-prefixed (IX/IY) instructions to the instruction map
 func (m InstructionMap) initIndexInstructions() {
-	// IX instructions (DD prefix)
-	m["ADD IX,BC"] = Instruction{0x09, 0xDD, RegisterPair, 2, 15, false}
-	m["ADD IX,DE"] = Instruction{0x19, 0xDD, RegisterPair, 2, 15, false}
-	m["ADD IX,IX"] = Instruction{0x29, 0xDD, RegisterPair, 2, 15, false}
-	m["ADD IX,SP"] = Instruction{0x39, 0xDD, RegisterPair, 2, 15, false}
-
-	m["LD IX,nn"] = Instruction{0x21, 0xDD, ImmediateExt, 4, 14, false}
-	m["LD (nn),IX"] = Instruction{0x22, 0xDD, Extended, 4, 20, false}
-	m["LD IX,(nn)"] = Instruction{0x2A, 0xDD, Extended, 4, 20, false}
-	m["LD SP,IX"] = Instruction{0xF9, 0xDD, Register, 2, 10, false}
-
-	m["INC IX"] = Instruction{0x23, 0xDD, Register, 2, 10, false}
-	m["DEC IX"] = Instruction{0x2B, 0xDD, Register, 2, 10, false}
-
-	// IX with displacement (d)
-	m["LD (IX+d),n"] = Instruction{0x36, 0xDD, Indexed, 4, 19, false}
-	m["LD (IX+d),B"] = Instruction{0x70, 0xDD, Indexed, 3, 19, false}
-	m["LD (IX+d),C"] = Instruction{0x71, 0xDD, Indexed, 3, 19, false}
-	m["LD (IX+d),D"] = Instruction{0x72, 0xDD, Indexed, 3, 19, false}
-	m["LD (IX+d),E"] = Instruction{0x73, 0xDD, Indexed, 3, 19, false}
-	m["LD (IX+d),H"] = Instruction{0x74, 0xDD, Indexed, 3, 19, false}
-	m["LD (IX+d),L"] = Instruction{0x75, 0xDD, Indexed, 3, 19, false}
-	m["LD (IX+d),A"] = Instruction{0x77, 0xDD, Indexed, 3, 19, false}
-
-	m["LD B,(IX+d)"] = Instruction{0x46, 0xDD, Indexed, 3, 19, false}
-	m["LD C,(IX+d)"] = Instruction{0x4E, 0xDD, Indexed, 3, 19, false}
-	m["LD D,(IX+d)"] = Instruction{0x56, 0xDD, Indexed, 3, 19, false}
-	m["LD E,(IX+d)"] = Instruction{0x5E, 0xDD, Indexed, 3, 19, false}
-	m["LD H,(IX+d)"] = Instruction{0x66, 0xDD, Indexed, 3, 19, false}
-	m["LD L,(IX+d)"] = Instruction{0x6E, 0xDD, Indexed, 3, 19, false}
-	m["LD A,(IX+d)"] = Instruction{0x7E, 0xDD, Indexed, 3, 19, false}
-
-	// IY instructions (FD prefix) - Mirror of IX instructions
-	m["ADD IY,BC"] = Instruction{0x09, 0xFD, RegisterPair, 2, 15, false}
-	m["ADD IY,DE"] = Instruction{0x19, 0xFD, RegisterPair, 2, 15, false}
-	m["ADD IY,IY"] = Instruction{0x29, 0xFD, RegisterPair, 2, 15, false}
-	m["ADD IY,SP"] = Instruction{0x39, 0xFD, RegisterPair, 2, 15, false}
-
-	m["LD IY,nn"] = Instruction{0x21, 0xFD, ImmediateExt, 4, 14, false}
-	m["LD (nn),IY"] = Instruction{0x22, 0xFD, Extended, 4, 20, false}
-	m["LD IY,(nn)"] = Instruction{0x2A, 0xFD, Extended, 4, 20, false}
-	m["LD SP,IY"] = Instruction{0xF9, 0xFD, Register, 2, 10, false}
-
-	m["INC IY"] = Instruction{0x23, 0xFD, Register, 2, 10, false}
-	m["DEC IY"] = Instruction{0x2B, 0xFD, Register, 2, 10, false}
-
-	// IY with displacement (d)
-	m["LD (IY+d),n"] = Instruction{0x36, 0xFD, Indexed, 4, 19, false}
-	m["LD (IY+d),B"] = Instruction{0x70, 0xFD, Indexed, 3, 19, false}
-	m["LD (IY+d),C"] = Instruction{0x71, 0xFD, Indexed, 3, 19, false}
-	m["LD (IY+d),D"] = Instruction{0x72, 0xFD, Indexed, 3, 19, false}
-	m["LD (IY+d),E"] = Instruction{0x73, 0xFD, Indexed, 3, 19, false}
-	m["LD (IY+d),H"] = Instruction{0x74, 0xFD, Indexed, 3, 19, false}
-	m["LD (IY+d),L"] = Instruction{0x75, 0xFD, Indexed, 3, 19, false}
-	m["LD (IY+d),A"] = Instruction{0x77, 0xFD, Indexed, 3, 19, false}
-
-	m["LD B,(IY+d)"] = Instruction{0x46, 0xFD, Indexed, 3, 19, false}
-	m["LD C,(IY+d)"] = Instruction{0x4E, 0xFD, Indexed, 3, 19, false}
-	m["LD D,(IY+d)"] = Instruction{0x56, 0xFD, Indexed, 3, 19, false}
-	m["LD E,(IY+d)"] = Instruction{0x5E, 0xFD, Indexed, 3, 19, false}
-	m["LD H,(IY+d)"] = Instruction{0x66, 0xFD, Indexed, 3, 19, false}
-	m["LD L,(IY+d)"] = Instruction{0x6E, 0xFD, Indexed, 3, 19, false}
-	m["LD A,(IY+d)"] = Instruction{0x7E, 0xFD, Indexed, 3, 19, false}
-
-	// Arithmetic and logic with indexed addressing
-	// For IX
-	m["ADD A,(IX+d)"] = Instruction{0x86, 0xDD, Indexed, 3, 19, false}
-	m["ADC A,(IX+d)"] = Instruction{0x8E, 0xDD, Indexed, 3, 19, false}
-	m["SUB (IX+d)"] = Instruction{0x96, 0xDD, Indexed, 3, 19, false}
-	m["SBC A,(IX+d)"] = Instruction{0x9E, 0xDD, Indexed, 3, 19, false}
-	m["AND (IX+d)"] = Instruction{0xA6, 0xDD, Indexed, 3, 19, false}
-	m["XOR (IX+d)"] = Instruction{0xAE, 0xDD, Indexed, 3, 19, false}
-	m["OR (IX+d)"] = Instruction{0xB6, 0xDD, Indexed, 3, 19, false}
-	m["CP (IX+d)"] = Instruction{0xBE, 0xDD, Indexed, 3, 19, false}
-
-	// For IY
-	m["ADD A,(IY+d)"] = Instruction{0x86, 0xFD, Indexed, 3, 19, false}
-	m["ADC A,(IY+d)"] = Instruction{0x8E, 0xFD, Indexed, 3, 19, false}
-	m["SUB (IY+d)"] = Instruction{0x96, 0xFD, Indexed, 3, 19, false}
-	m["SBC A,(IY+d)"] = Instruction{0x9E, 0xFD, Indexed, 3, 19, false}
-	m["AND (IY+d)"] = Instruction{0xA6, 0xFD, Indexed, 3, 19, false}
-	m["XOR (IY+d)"] = Instruction{0xAE, 0xFD, Indexed, 3, 19, false}
-	m["OR (IY+d)"] = Instruction{0xB6, 0xFD, Indexed, 3, 19, false}
-	m["CP (IY+d)"] = Instruction{0xBE, 0xFD, Indexed, 3, 19, false}
-
-	// Inc/Dec indexed memory
-	m["INC (IX+d)"] = Instruction{0x34, 0xDD, Indexed, 3, 23, false}
-	m["DEC (IX+d)"] = Instruction{0x35, 0xDD, Indexed, 3, 23, false}
-	m["INC (IY+d)"] = Instruction{0x34, 0xFD, Indexed, 3, 23, false}
-	m["DEC (IY+d)"] = Instruction{0x35, 0xFD, Indexed, 3, 23, false}
-
-	// Bit operations with indexed addressing (DDCB/FDCB prefixed)
-	for bit := 0; bit < 8; bit++ {
-		// BIT instructions
-		m[fmt.Sprintf("BIT %d,(IX+d)", bit)] = Instruction{byte(0x46 + (bit << 3)), 0xDD, IndexedBit, 4, 20, false}
-		m[fmt.Sprintf("BIT %d,(IY+d)", bit)] = Instruction{byte(0x46 + (bit << 3)), 0xFD, IndexedBit, 4, 20, false}
-		
-		// SET instructions
-		m[fmt.Sprintf("SET %d,(IX+d)", bit)] = Instruction{byte(0xC6 + (bit << 3)), 0xDD, IndexedBit, 4, 23, false}
-		m[fmt.Sprintf("SET %d,(IY+d)", bit)] = Instruction{byte(0xC6 + (bit << 3)), 0xFD, IndexedBit, 4, 23, false}
-		
-		// RES instructions
-		m[fmt.Sprintf("RES %d,(IX+d)", bit)] = Instruction{byte(0x86 + (bit << 3)), 0xDD, IndexedBit, 4, 23, false}
-		m[fmt.Sprintf("RES %d,(IY+d)", bit)] = Instruction{byte(0x86 + (bit << 3)), 0xFD, IndexedBit, 4, 23, false}
+	for _, ix := range indexRegs {
+		x, prefix := ix.name, ix.prefix
+		mem := "(" + x + "+d)"
+
+		// 16-bit load group
+		m["LD "+x+",nn"] = Instruction{0x21, prefix, ImmediateExt, 4, 14, false}
+		m["LD (nn),"+x] = Instruction{0x22, prefix, Extended, 4, 20, false}
+		m["LD "+x+",(nn)"] = Instruction{0x2A, prefix, Extended, 4, 20, false}
+		m["LD SP,"+x] = Instruction{0xF9, prefix, Register, 2, 10, false}
+		m["PUSH "+x] = Instruction{0xE5, prefix, RegisterPair, 2, 15, false}
+		m["POP "+x] = Instruction{0xE1, prefix, RegisterPair, 2, 14, false}
+		m["EX (SP),"+x] = Instruction{0xE3, prefix, RegisterIndirect, 2, 23, false}
+
+		// 16-bit arithmetic group; the index register replaces HL
+		for p, pp := range regPairs {
+			if pp == "HL" {
+				pp = x
+			}
+			m["ADD "+x+","+pp] = Instruction{byte(0x09 | p<<4), prefix, RegisterPair, 2, 15, false}
+		}
+		m["INC "+x] = Instruction{0x23, prefix, Register, 2, 10, false}
+		m["DEC "+x] = Instruction{0x2B, prefix, Register, 2, 10, false}
+
+		// Jump group
+		m["JP ("+x+")"] = Instruction{0xE9, prefix, RegisterIndirect, 2, 8, false}
+
+		// Loads with displacement (d)
+		m["LD "+mem+",n"] = Instruction{0x36, prefix, IndexedImmediate, 4, 19, false}
+		for r, reg := range regs8 {
+			if r == 6 {
+				continue
+			}
+			m["LD "+reg+","+mem] = Instruction{byte(0x46 | r<<3), prefix, Indexed, 3, 19, false}
+			m["LD "+mem+","+reg] = Instruction{byte(0x70 | r), prefix, Indexed, 3, 19, false}
+		}
+
+		// Arithmetic and logic with indexed addressing
+		for op, alu := range aluOps {
+			m[alu+mem] = Instruction{byte(0x86 | op<<3), prefix, Indexed, 3, 19, false}
+		}
+
+		// Inc/Dec indexed memory
+		m["INC "+mem] = Instruction{0x34, prefix, Indexed, 3, 23, false}
+		m["DEC "+mem] = Instruction{0x35, prefix, Indexed, 3, 23, false}
+
+		// Rotation and shift with indexed addressing (DDCB/FDCB prefixed)
+		for op, name := range cbShiftOps {
+			if name == "" {
+				continue
+			}
+			m[name+" "+mem] = Instruction{byte(op<<3 | 6), prefix, IndexedBit, 4, 23, false}
+		}
+
+		// Bit operations with indexed addressing (DDCB/FDCB prefixed)
+		for bit := 0; bit < 8; bit++ {
+			m[fmt.Sprintf("BIT %d,%s", bit, mem)] = Instruction{byte(0x46 | bit<<3), prefix, IndexedBit, 4, 20, false}
+			m[fmt.Sprintf("RES %d,%s", bit, mem)] = Instruction{byte(0x86 | bit<<3), prefix, IndexedBit, 4, 23, false}
+			m[fmt.Sprintf("SET %d,%s", bit, mem)] = Instruction{byte(0xC6 | bit<<3), prefix, IndexedBit, 4, 23, false}
+		}
 	}
-}
\ No newline at end of file
+}
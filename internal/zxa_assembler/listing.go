@@ -0,0 +1,91 @@
+// file: internal/zxa_assembler/listing.go
+
+package zxa_assembler
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// listingBytesPerRow is the number of bytes shown beside each source line;
+// longer data continues on rows of its own
+const listingBytesPerRow = 4
+
+// ListingLine is one source line of the final pass with the bytes it
+// assembled to
+type ListingLine struct {
+	File    string
+	Line    int
+	Address int  // Physical address of the first byte
+	Logical int  // Address the code runs at, the value of $
+	Phased  bool // Inside PHASE, where Logical differs from Address
+	Bytes   []byte
+	Source  string
+}
+
+// SetListingOutput configures listing output
+func (a *Assembler) SetListingOutput(enabled bool) {
+	a.listOutput = enabled
+}
+
+// listLine starts the listing entry of a source line. Bytes emitted from
+// here on belong to it, until the next line or until the parser reading a
+// nested INCLUDE or macro body gives back the enclosing line.
+func (a *Assembler) listLine(file string, line int, source string) {
+	if !a.listOutput || a.pass != finalPass {
+		return
+	}
+	a.listing = append(a.listing, ListingLine{
+		File:    file,
+		Line:    line,
+		Address: a.currentAddr,
+		Logical: a.logicalAddr(),
+		Phased:  a.phase != nil,
+		Source:  source,
+	})
+	a.listIndex = len(a.listing) - 1
+}
+
+// listByte adds an emitted byte to the current listing entry
+func (a *Assembler) listByte(b byte) {
+	if a.listIndex >= 0 {
+		a.listing[a.listIndex].Bytes = append(a.listing[a.listIndex].Bytes, b)
+	}
+}
+
+// generateListing formats the listing: line number, physical address,
+// logical address inside PHASE, bytes and source. A comment line names
+// the file whenever it changes, as at an INCLUDE or macro expansion.
+func (a *Assembler) generateListing() string {
+	var sb strings.Builder
+	file := ""
+	for _, l := range a.listing {
+		if l.File != file {
+			file = l.File
+			fmt.Fprintf(&sb, "; %s\n", filepath.Clean(file))
+		}
+		for row := 0; row == 0 || row*listingBytesPerRow < len(l.Bytes); row++ {
+			start := row * listingBytesPerRow
+			end := min(start+listingBytesPerRow, len(l.Bytes))
+
+			hex := make([]string, 0, listingBytesPerRow)
+			for _, b := range l.Bytes[start:end] {
+				hex = append(hex, fmt.Sprintf("%02X", b))
+			}
+			logical := "    "
+			if l.Phased {
+				logical = fmt.Sprintf("%04X", uint16(l.Logical+start))
+			}
+
+			number, source := "", ""
+			if row == 0 {
+				number, source = fmt.Sprint(l.Line), l.Source
+			}
+			text := fmt.Sprintf("%5s  %04X %s  %-12s%s", number, uint16(l.Address+start), logical,
+				strings.Join(hex, " "), source)
+			sb.WriteString(strings.TrimRight(text, " ") + "\n")
+		}
+	}
+	return sb.String()
+}
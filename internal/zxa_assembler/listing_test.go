@@ -0,0 +1,73 @@
+// file: internal/zxa_assembler/listing_test.go
+
+package zxa_assembler
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPHASE(t *testing.T) {
+	src := ` ORG $8000
+ ld hl, copy
+ ld de, $C000
+ PHASE $C000
+copy: jp there
+there: jr there
+ DEPHASE
+after: DW after
+`
+	a, result, err := assembleSource(t, src)
+	want := []byte{0x21, 0x00, 0xC0, 0x11, 0x00, 0xC0, 0xC3, 0x03, 0xC0, 0x18, 0xFE, 0x0B, 0x80}
+	checkResult(t, result, err, want, "")
+	checkSymbols(t, a, map[string]int{"copy": 0xC000, "there": 0xC003, "after": 0x800B})
+	if p := a.symbols["copy"].Physical; p == nil || *p != 0x8006 {
+		t.Errorf("copy should be stored at $8006, got %v", p)
+	}
+
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{name: "DISP and ENT", src: " ORG $8000\n DISP $4000\nx: DW x\n ENT\n", want: []byte{0x00, 0x40}},
+		{
+			name: "ent and disp as labels",
+			src:  " ORG $8000\ndisp: DISP $4000\nEnt: DW disp, Ent\n ENT\nent: DW ent\n",
+			want: []byte{0x00, 0x80, 0x00, 0x40, 0x04, 0x80},
+		},
+		{name: "ALIGN uses the logical address", src: " ORG $8001\n PHASE $4000\n ALIGN 2\n DB 1\n DEPHASE\n", want: []byte{1}},
+		{name: "unclosed", src: " PHASE $4000\n nop\n", wantErr: "PHASE without DEPHASE"},
+		{name: "nested", src: " PHASE $4000\n PHASE $5000\n DEPHASE\n", wantErr: "PHASE"},
+		{name: "DEPHASE alone", src: " DEPHASE\n", wantErr: "DEPHASE without PHASE"},
+		{name: "ORG inside", src: " PHASE $4000\n ORG $8000\n DEPHASE\n", wantErr: "ORG inside PHASE"},
+	})
+}
+
+func TestListing(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.asm": " ORG $8000\nstart: ld a, 1\n DB 1, 2, 3, 4, 5\n PHASE $C000\n nop\n DEPHASE\n",
+	})
+	a := NewAssembler(AssemblerOptions{})
+	a.SetListingOutput(true)
+	result, err := a.Assemble(filepath.Join(dir, "main.asm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimRight(result.Listing, "\n"), "\n")
+	want := []string{
+		"; " + filepath.Join(dir, "main.asm"),
+		"    1  0000                    ORG $8000",
+		"    2  8000       3E 01       start: ld a, 1",
+		"    3  8002       01 02 03 04  DB 1, 2, 3, 4, 5",
+		"       8006       05",
+		"    4  8007                    PHASE $C000",
+		"    5  8007 C000  00           nop",
+		"    6  8008 C001               DEPHASE",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), len(want), result.Listing)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d:\ngot  %q\nwant %q", i, lines[i], want[i])
+		}
+	}
+}
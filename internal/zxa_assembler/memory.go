@@ -0,0 +1,94 @@
+// file: internal/zxa_assembler/memory.go
+
+package zxa_assembler
+
+import "fmt"
+
+// memorySize is the size of the Z80 address space
+const memorySize = 0x10000
+
+// Segment is a contiguous run of written memory
+type Segment struct {
+	Start  int    `json:"start"`
+	End    int    `json:"end"` // Last written address, inclusive
+	Length int    `json:"length"`
+	Data   []byte `json:"-"`
+}
+
+// Memory is a sparse image of the 64K address space. It remembers which
+// addresses were written so output can keep each ORG block in place.
+type Memory struct {
+	data    [memorySize]byte
+	written [memorySize]bool
+	count   int
+}
+
+// write stores a byte, reporting false if the address was already written
+func (m *Memory) write(addr int, b byte) bool {
+	overlap := m.written[addr]
+	if !overlap {
+		m.written[addr] = true
+		m.count++
+	}
+	m.data[addr] = b
+	return !overlap
+}
+
+// segments returns the contiguous written ranges in address order
+func (m *Memory) segments() []Segment {
+	var segs []Segment
+	for addr := 0; addr < memorySize; addr++ {
+		if !m.written[addr] {
+			continue
+		}
+		start := addr
+		for addr < memorySize && m.written[addr] {
+			addr++
+		}
+		segs = append(segs, Segment{
+			Start:  start,
+			End:    addr - 1,
+			Length: addr - start,
+			Data:   append([]byte(nil), m.data[start:addr]...),
+		})
+	}
+	return segs
+}
+
+// image returns memory from the lowest to the highest written address,
+// with the gaps between segments filled with fill
+func (m *Memory) image(fill byte) (int, []byte) {
+	segs := m.segments()
+	if len(segs) == 0 {
+		return 0, nil
+	}
+	start, end := segs[0].Start, segs[len(segs)-1].End
+	img := make([]byte, end-start+1)
+	for i := range img {
+		img[i] = fill
+	}
+	for _, seg := range segs {
+		copy(img[seg.Start-start:], seg.Data)
+	}
+	return start, img
+}
+
+// memoryError collects the problems found while one statement writes memory,
+// so each statement reports an overlap once rather than once per byte
+type memoryError struct {
+	overlapStart, overlapEnd int
+	overflow                 bool
+}
+
+// message describes the problem
+func (e *memoryError) message() string {
+	switch {
+	case e.overflow:
+		return "code extends beyond the 64K address space"
+	case e.overlapStart == e.overlapEnd:
+		return fmt.Sprintf("overlapping write: $%04X was already written", e.overlapStart)
+	default:
+		return fmt.Sprintf("overlapping write: $%04X-$%04X were already written",
+			e.overlapStart, e.overlapEnd)
+	}
+}
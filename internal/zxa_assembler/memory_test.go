@@ -0,0 +1,101 @@
+// file: internal/zxa_assembler/memory_test.go
+
+package zxa_assembler
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestORGSegments(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		origin   int
+		image    []byte
+		segments []Segment
+	}{
+		{
+			name:     "single block",
+			src:      " ORG $8000\n DEFB 1, 2\n",
+			origin:   0x8000,
+			image:    []byte{1, 2},
+			segments: []Segment{{Start: 0x8000, End: 0x8001, Length: 2}},
+		},
+		{
+			name:   "gap is zero-filled",
+			src:    " ORG $8000\n DEFB 1\n ORG $8004\n DEFB 2\n",
+			origin: 0x8000,
+			image:  []byte{1, 0, 0, 0, 2},
+			segments: []Segment{
+				{Start: 0x8000, End: 0x8000, Length: 1},
+				{Start: 0x8004, End: 0x8004, Length: 1},
+			},
+		},
+		{
+			name:   "blocks out of order",
+			src:    " ORG $9000\n DEFB 2\n ORG $8FFE\n DEFB 1\n",
+			origin: 0x8FFE,
+			image:  []byte{1, 0, 2},
+			segments: []Segment{
+				{Start: 0x8FFE, End: 0x8FFE, Length: 1},
+				{Start: 0x9000, End: 0x9000, Length: 1},
+			},
+		},
+		{
+			name:     "adjacent blocks merge",
+			src:      " ORG $8001\n DEFB 2\n ORG $8000\n DEFB 1\n",
+			origin:   0x8000,
+			image:    []byte{1, 2},
+			segments: []Segment{{Start: 0x8000, End: 0x8001, Length: 2}},
+		},
+		{
+			name:     "DEFS fills its space",
+			src:      " ORG $8000\n DEFB 1\n DEFS 2, $FF\n DEFB 2\n",
+			origin:   0x8000,
+			image:    []byte{1, 0xFF, 0xFF, 2},
+			segments: []Segment{{Start: 0x8000, End: 0x8003, Length: 4}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, result, err := assembleSource(t, tc.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Origin != tc.origin || !bytes.Equal(result.Binary, tc.image) {
+				t.Fatalf("got $%04X % X, want $%04X % X", result.Origin, result.Binary, tc.origin, tc.image)
+			}
+			if len(result.Segments) != len(tc.segments) {
+				t.Fatalf("got %d segments, want %d", len(result.Segments), len(tc.segments))
+			}
+			for i, seg := range result.Segments {
+				want := tc.segments[i]
+				if seg.Start != want.Start || seg.End != want.End || seg.Length != want.Length {
+					t.Errorf("segment %d: got $%04X-$%04X (%d), want $%04X-$%04X (%d)",
+						i, seg.Start, seg.End, seg.Length, want.Start, want.End, want.Length)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryErrors(t *testing.T) {
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{
+			name:    "overlap",
+			src:     " ORG $8000\n DEFB 1, 2, 3\n ORG $8001\n DEFB 4, 5\n",
+			wantErr: "overlapping write: $8001-$8002 were already written",
+		},
+		{
+			name:    "beyond 64K",
+			src:     " ORG $FFFF\n DEFW 1\n",
+			wantErr: "code extends beyond the 64K address space",
+		},
+		{
+			name:    "ORG out of range",
+			src:     " ORG $10000\n",
+			wantErr: "ORG address out of range",
+		},
+	})
+}
@@ -0,0 +1,275 @@
+// file: internal/zxa_assembler/output.go
+
+package zxa_assembler
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// OutputFile is a file asked for by an output directive in the source
+type OutputFile struct {
+	Path   string `json:"path"`
+	Format string `json:"format"` // Directive that asked for it, such as SAVETAP
+	Size   int    `json:"size"`
+	Data   []byte `json:"-"`
+}
+
+// Spectrum memory layout used by the tape, snapshot and NEX formats
+const (
+	ramStart       = 0x4000 // Everything below is ROM
+	screenEnd      = 0x5B00 // End of the display and attributes
+	tapeNameLength = 10
+	bankSize       = 0x4000 // 16K memory bank
+	pageSize       = 0x2000 // 8K memory page of the Next
+)
+
+// nexBanks are the banks the Spectrum maps at $4000, $8000 and $C000
+var nexBanks = []int{5, 2, 0}
+
+// buildOutputs builds the files asked for by output directives from the
+// finished memory image. Problems are added to the error list.
+func (a *Assembler) buildOutputs() []OutputFile {
+	var files []OutputFile
+	tapes := make(map[string]int)
+	formats := make(map[string]string)
+	blocks := make(map[string][]saveRequest)
+
+	for _, req := range a.saves {
+		// Only tape blocks may share a file; they are appended in order
+		if format, exists := formats[req.path]; exists && (format != "SAVETAP" || req.format != "SAVETAP") {
+			a.errors.Add(directiveError(req.from.File, req.from.Line, "%s is already written by %s", req.path, format))
+			continue
+		}
+		formats[req.path] = req.format
+
+		var data []byte
+		var err error
+		switch req.format {
+		case "SAVEBIN":
+			data, err = a.saveRange(&req)
+		case "SAVETAP":
+			if _, err = a.saveRange(&req); err == nil {
+				if _, exists := tapes[req.path]; !exists {
+					tapes[req.path] = len(files)
+					files = append(files, OutputFile{Path: req.path, Format: req.format})
+				}
+				blocks[req.path] = append(blocks[req.path], req)
+				continue
+			}
+		case "SAVESNA", "SAVENEX":
+			if req.entry < 0 && a.entryFrom == nil {
+				err = directiveError(req.from.File, req.from.Line, "%s requires an entry address, or END with one",
+					req.format)
+				break
+			}
+			if req.entry < 0 {
+				req.entry = a.entry
+			}
+			if req.format == "SAVESNA" {
+				data, err = a.snapshot(req)
+			} else {
+				data, err = a.nexFile(req)
+			}
+		}
+		if err != nil {
+			var diag AssemblerError
+			if !errors.As(err, &diag) {
+				diag = fileError(req.from.File, req.from.Line, "%s: %v", req.format, err)
+			}
+			a.errors.Add(diag)
+			continue
+		}
+		files = append(files, OutputFile{Path: req.path, Format: req.format, Size: len(data), Data: data})
+	}
+
+	for path, i := range tapes {
+		files[i].Data = a.tape(blocks[path])
+		files[i].Size = len(files[i].Data)
+	}
+	return files
+}
+
+// saveRange returns the bytes of the range a SAVEBIN or SAVETAP names,
+// settling a missing length as the end of the code
+func (a *Assembler) saveRange(req *saveRequest) ([]byte, error) {
+	if req.length < 0 {
+		segs := a.memory.segments()
+		if len(segs) == 0 || segs[len(segs)-1].End < req.start {
+			return nil, rangeError(req.from.File, req.from.Line, "%s: no code at or after $%04X",
+				req.format, req.start)
+		}
+		req.length = segs[len(segs)-1].End + 1 - req.start
+	}
+	return append([]byte(nil), a.memory.data[req.start:req.start+req.length]...), nil
+}
+
+// checkRAM reports code below the RAM, which snapshots cannot hold
+func (a *Assembler) checkRAM(req saveRequest) error {
+	for addr := 0; addr < ramStart; addr++ {
+		if a.memory.written[addr] {
+			return rangeError(req.from.File, req.from.Line, "%s cannot hold code in ROM at $%04X",
+				req.format, addr)
+		}
+	}
+	return nil
+}
+
+// tape builds a TAP file: a BASIC loader that loads each block and runs
+// the code, followed by a header and data block for each range. The code
+// is run from the address given by END, or else from the first block
+// above the screen.
+func (a *Assembler) tape(blocks []saveRequest) []byte {
+	clear, entry := -1, -1
+	if a.entryFrom != nil {
+		entry = a.entry
+	}
+	for _, req := range blocks {
+		if req.start >= screenEnd && (clear < 0 || req.start-1 < clear) {
+			clear = req.start - 1
+		}
+		if req.start >= screenEnd && entry < 0 {
+			entry = req.start
+		}
+	}
+
+	// 10 CLEAR c: LOAD ""CODE: ...: RANDOMIZE USR e
+	const (
+		tokCLEAR     = 0xFD
+		tokLOAD      = 0xEF
+		tokCODE      = 0xAF
+		tokRANDOMIZE = 0xF9
+		tokUSR       = 0xC0
+	)
+	var basic []byte
+	if clear >= 24000 {
+		basic = append(append(basic, tokCLEAR), basicNumber(clear)...)
+	}
+	for range blocks {
+		if len(basic) > 0 {
+			basic = append(basic, ':')
+		}
+		basic = append(basic, tokLOAD, '"', '"', tokCODE)
+	}
+	if entry >= 0 {
+		basic = append(append(basic, ':', tokRANDOMIZE, tokUSR), basicNumber(entry)...)
+	}
+	basic = append(basic, 0x0D)
+
+	const loaderLine = 10
+	program := []byte{0, loaderLine}
+	program = binary.LittleEndian.AppendUint16(program, uint16(len(basic)))
+	program = append(program, basic...)
+
+	var tap []byte
+	tap = append(tap, tapeBlock(0x00, tapeHeader(0, blocks[0].name, len(program), loaderLine, len(program)))...)
+	tap = append(tap, tapeBlock(0xFF, program)...)
+	for _, req := range blocks {
+		data := a.memory.data[req.start : req.start+req.length]
+		tap = append(tap, tapeBlock(0x00, tapeHeader(3, req.name, len(data), req.start, 32768))...)
+		tap = append(tap, tapeBlock(0xFF, data)...)
+	}
+	return tap
+}
+
+// tapeHeader builds the 17 bytes of a tape header: 0 for a program, 3 for
+// code, followed by the name, length and two parameters
+func tapeHeader(kind byte, name string, length, param1, param2 int) []byte {
+	header := []byte{kind}
+	for i := 0; i < tapeNameLength; i++ {
+		if i < len(name) {
+			header = append(header, name[i])
+		} else {
+			header = append(header, ' ')
+		}
+	}
+	header = binary.LittleEndian.AppendUint16(header, uint16(length))
+	header = binary.LittleEndian.AppendUint16(header, uint16(param1))
+	return binary.LittleEndian.AppendUint16(header, uint16(param2))
+}
+
+// tapeBlock wraps data as a TAP block: length, flag, data and checksum
+func tapeBlock(flag byte, data []byte) []byte {
+	block := binary.LittleEndian.AppendUint16(nil, uint16(len(data)+2))
+	block = append(block, flag)
+	block = append(block, data...)
+	checksum := flag
+	for _, b := range data {
+		checksum ^= b
+	}
+	return append(block, checksum)
+}
+
+// basicNumber encodes a number in a BASIC line: its digits, then the
+// hidden five-byte form the interpreter reads
+func basicNumber(n int) []byte {
+	number := []byte(strconv.Itoa(n))
+	return append(number, 0x0E, 0, 0, byte(n), byte(n>>8), 0)
+}
+
+// snapshot builds a 48K SNA file that starts running at the entry address.
+// The format resumes with RETN, so the entry is pushed on a stack placed
+// in the highest two bytes of RAM the code leaves free. RAM holds only the
+// assembled code, without the system variables the ROM keeps from $5C00,
+// so the program starts with interrupts disabled: the ROM interrupt
+// handler must not run until the program has set up what it needs.
+func (a *Assembler) snapshot(req saveRequest) ([]byte, error) {
+	if err := a.checkRAM(req); err != nil {
+		return nil, err
+	}
+	sp := memorySize - 2
+	for sp > ramStart && (a.memory.written[sp] || a.memory.written[sp+1]) {
+		sp--
+	}
+	if sp == ramStart {
+		return nil, rangeError(req.from.File, req.from.Line, "SAVESNA found no free RAM for the stack")
+	}
+
+	header := make([]byte, 27)
+	header[0] = 0x3F                                       // I, as the ROM sets it
+	binary.LittleEndian.PutUint16(header[15:], 0x5C3A)     // IY, as the ROM expects
+	header[19] = 0                                         // Interrupts disabled
+	binary.LittleEndian.PutUint16(header[23:], uint16(sp)) // SP
+	header[25] = 1                                         // IM 1
+	header[26] = 7                                         // White border
+
+	ram := append([]byte(nil), a.memory.data[ramStart:]...)
+	binary.LittleEndian.PutUint16(ram[sp-ramStart:], uint16(req.entry))
+	return append(header, ram...), nil
+}
+
+// nexFile builds a ZX Spectrum Next NEX file (format V1.2). The 48K above
+// the ROM maps to 16K banks 5, 2 and 0, of which those listed, or else
+// those holding code, are stored in that order.
+func (a *Assembler) nexFile(req saveRequest) ([]byte, error) {
+	if err := a.checkRAM(req); err != nil {
+		return nil, err
+	}
+	sp := req.sp
+	if sp == 0 {
+		sp = memorySize - 2
+	}
+
+	header := make([]byte, 512)
+	copy(header, "NextV1.2")
+	header[11] = 7 // White border
+	binary.LittleEndian.PutUint16(header[12:], uint16(sp))
+	binary.LittleEndian.PutUint16(header[14:], uint16(req.entry))
+
+	var data []byte
+	for i, bank := range nexBanks {
+		start := ramStart + i*bankSize
+		used := indexInt(req.banks, bank) >= 0
+		for addr := start; req.banks == nil && addr < start+bankSize; addr++ {
+			used = used || a.memory.written[addr]
+		}
+		if !used {
+			continue
+		}
+		header[9]++
+		header[18+bank] = 1
+		data = append(data, a.memory.data[start:start+bankSize]...)
+	}
+	return append(header, data...), nil
+}
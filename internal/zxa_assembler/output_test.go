@@ -0,0 +1,194 @@
+// file: internal/zxa_assembler/output_test.go
+
+package zxa_assembler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+)
+
+// outputFile returns the output file written to name, failing if there is none
+func outputFile(t *testing.T, result AssemblyResult, name string) OutputFile {
+	t.Helper()
+	for _, out := range result.OutputFiles {
+		if filepath.Base(out.Path) == name {
+			return out
+		}
+	}
+	t.Fatalf("no output file %s", name)
+	return OutputFile{}
+}
+
+// tapBlocks splits a TAP file into the flag and data of each block,
+// checking every checksum
+func tapBlocks(t *testing.T, tap []byte) [][]byte {
+	t.Helper()
+	var blocks [][]byte
+	for len(tap) > 0 {
+		n := int(binary.LittleEndian.Uint16(tap))
+		block := tap[2 : 2+n]
+		var sum byte
+		for _, b := range block[:n-1] {
+			sum ^= b
+		}
+		if sum != block[n-1] {
+			t.Fatalf("bad checksum in block %d", len(blocks))
+		}
+		blocks = append(blocks, block[:n-1])
+		tap = tap[2+n:]
+	}
+	return blocks
+}
+
+const outputSource = ` ORG $8000
+start: ld a, 2
+ ret
+end_code:
+ ORG $C000
+table: DB 1, 2, 3
+`
+
+func TestSAVEBIN(t *testing.T) {
+	src := outputSource + ` SAVEBIN "code.bin", start, end_code-start
+ SAVEBIN "bin/rest.bin", table
+`
+	_, result, err := assembleSource(t, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := outputFile(t, result, "code.bin"); !bytes.Equal(out.Data, []byte{0x3E, 0x02, 0xC9}) || out.Format != "SAVEBIN" {
+		t.Errorf("code.bin: % X", out.Data)
+	}
+	out := outputFile(t, result, "rest.bin")
+	if !bytes.Equal(out.Data, []byte{1, 2, 3}) || filepath.Base(filepath.Dir(out.Path)) != "bin" {
+		t.Errorf("%s: % X", out.Path, out.Data)
+	}
+}
+
+func TestSAVETAP(t *testing.T) {
+	src := outputSource + ` SAVETAP "game.tap", start, 3, "Game"
+ SAVETAP "game.tap", table, 3
+`
+	_, result, err := assembleSource(t, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := tapBlocks(t, outputFile(t, result, "game.tap").Data)
+	if len(blocks) != 6 {
+		t.Fatalf("got %d blocks, want a loader and two code blocks with headers", len(blocks))
+	}
+
+	// Program header, then the loader: 10 CLEAR 32767: LOAD ""CODE: LOAD ""CODE: RANDOMIZE USR 32768
+	if blocks[0][0] != 0x00 || blocks[0][1] != 0 || string(blocks[0][2:12]) != "Game      " {
+		t.Errorf("program header: % X", blocks[0])
+	}
+	loader := blocks[1][1:]
+	want := []byte{0x00, 0x0A, byte(len(loader) - 4), 0x00, 0xFD, '3', '2', '7', '6', '7', 0x0E, 0, 0, 0xFF, 0x7F, 0,
+		':', 0xEF, '"', '"', 0xAF, ':', 0xEF, '"', '"', 0xAF,
+		':', 0xF9, 0xC0, '3', '2', '7', '6', '8', 0x0E, 0, 0, 0x00, 0x80, 0, 0x0D}
+	if !bytes.Equal(loader, want) {
+		t.Errorf("loader:\ngot  % X\nwant % X", loader, want)
+	}
+
+	// Code headers name the start and length of each block
+	for i, want := range []struct {
+		name  string
+		start int
+		data  []byte
+	}{
+		{"Game      ", 0x8000, []byte{0x3E, 0x02, 0xC9}},
+		{"game      ", 0xC000, []byte{1, 2, 3}},
+	} {
+		header, data := blocks[2+2*i], blocks[3+2*i]
+		if header[1] != 3 || string(header[2:12]) != want.name ||
+			int(binary.LittleEndian.Uint16(header[12:])) != len(want.data) ||
+			int(binary.LittleEndian.Uint16(header[14:])) != want.start {
+			t.Errorf("header %d: % X", i, header)
+		}
+		if data[0] != 0xFF || !bytes.Equal(data[1:], want.data) {
+			t.Errorf("data %d: % X", i, data)
+		}
+	}
+}
+
+func TestSAVESNA(t *testing.T) {
+	_, result, err := assembleSource(t, outputSource+" SAVESNA \"game.sna\", start\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sna := outputFile(t, result, "game.sna").Data
+	if len(sna) != 27+0xC000 {
+		t.Fatalf("snapshot is %d bytes", len(sna))
+	}
+	sp := int(binary.LittleEndian.Uint16(sna[23:]))
+	if sp != 0xFFFE || binary.LittleEndian.Uint16(sna[27+sp-0x4000:]) != 0x8000 {
+		t.Errorf("SP $%04X does not hold the entry address", sp)
+	}
+	if !bytes.Equal(sna[27+0x4000:27+0x4003], []byte{0x3E, 0x02, 0xC9}) || sna[25] != 1 || sna[19]&0x04 != 0 {
+		t.Errorf("unexpected snapshot contents")
+	}
+}
+
+func TestSAVENEX(t *testing.T) {
+	_, result, err := assembleSource(t, outputSource+" SAVENEX \"game.nex\", start, $BFF0\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nex := outputFile(t, result, "game.nex").Data
+	if string(nex[:8]) != "NextV1.2" || len(nex) != 512+2*0x4000 {
+		t.Fatalf("unexpected NEX header or size %d", len(nex))
+	}
+	if nex[9] != 2 || nex[18+2] != 1 || nex[18+0] != 1 || nex[18+5] != 0 {
+		t.Errorf("banks: count %d, flags % X", nex[9], nex[18:26])
+	}
+	if binary.LittleEndian.Uint16(nex[12:]) != 0xBFF0 || binary.LittleEndian.Uint16(nex[14:]) != 0x8000 {
+		t.Errorf("SP/PC: % X", nex[12:16])
+	}
+	if nex[512] != 0x3E || nex[512+0x4000] != 1 {
+		t.Errorf("bank 2 should come before bank 0")
+	}
+}
+
+func TestSavePages(t *testing.T) {
+	src := " ORG $8000\n DB 1, 2\n ORG $A000\n DB 3\nMAIN EQU 2\n" +
+		" SAVEBIN \"bank.bin\", BANK MAIN\n SAVEBIN \"page.bin\", PAGE 5, 2\n" +
+		" SAVENEX \"game.nex\", $8000, 0, BANK 0, BANK 5\n"
+	_, result, err := assembleSource(t, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bank := outputFile(t, result, "bank.bin").Data
+	if len(bank) != 0x4000 || bank[0] != 1 || bank[1] != 2 || bank[0x2000] != 3 {
+		t.Errorf("bank 2 is %d bytes, starting % X", len(bank), bank[:2])
+	}
+	if page := outputFile(t, result, "page.bin").Data; !bytes.Equal(page, []byte{3, 0}) {
+		t.Errorf("page 5: % X", page)
+	}
+
+	// The banks listed are stored, whether or not they hold code
+	nex := outputFile(t, result, "game.nex").Data
+	if len(nex) != 512+2*0x4000 || nex[9] != 2 || nex[18+5] != 1 || nex[18+0] != 1 || nex[18+2] != 0 {
+		t.Errorf("banks: size %d, count %d, flags % X", len(nex), nex[9], nex[18:26])
+	}
+}
+
+func TestOutputErrors(t *testing.T) {
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{name: "range past memory", src: " SAVEBIN \"a.bin\", $FFF0, $20\n", wantErr: "SAVEBIN range $FFF0+32 is outside memory"},
+		{name: "no code after start", src: " DB 1\n SAVEBIN \"a.bin\", $8000\n", wantErr: "SAVEBIN: no code at or after $8000"},
+		{name: "same file twice", src: " DB 1\n SAVEBIN \"a.bin\", 0, 1\n SAVETAP \"a.bin\", 0, 1\n", wantErr: "is already written by SAVEBIN"},
+		{name: "code in ROM", src: " DB 1\n SAVESNA \"a.sna\", 0\n", wantErr: "SAVESNA cannot hold code in ROM at $0000"},
+		{name: "long tape name", src: " SAVETAP \"a.tap\", 0, 1, \"elevenchars\"\n", wantErr: "tape name longer than 10 characters"},
+		{name: "bank outside memory", src: " DB 1\n SAVEBIN \"a.bin\", BANK 3\n", wantErr: "bank 3 is not in the 64K address space"},
+		{name: "page outside memory", src: " DB 1\n SAVEBIN \"a.bin\", page 3\n", wantErr: "page 3 is not in the 64K address space"},
+		{name: "page as a length", src: " DB 1\n SAVEBIN \"a.bin\", 0, PAGE 0\n", wantErr: "SAVEBIN requires"},
+		{name: "page on tape", src: " DB 1\n SAVETAP \"a.tap\", BANK 0, 1\n", wantErr: "SAVETAP requires"},
+		{name: "NEX page", src: " DB 1\n SAVENEX \"a.nex\", 0, 0, PAGE 4\n", wantErr: "SAVENEX stores 16K banks, not PAGE 4"},
+		{name: "NEX bank twice", src: " DB 1\n SAVENEX \"a.nex\", 0, 0, BANK 2, bank 2\n", wantErr: "SAVENEX lists bank 2 twice"},
+		{name: "NEX entry after banks", src: " DB 1\n SAVENEX \"a.nex\", BANK 5, 2\n", wantErr: "SAVENEX requires"},
+		{name: "a label named page", src: "page: DB 1\n SAVEBIN \"a.bin\", page, page + 1\n", want: []byte{1}},
+		{name: "no file name", src: " SAVEBIN 0, 1\n", wantErr: "SAVEBIN requires"},
+	})
+}
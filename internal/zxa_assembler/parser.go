@@ -3,6 +3,7 @@
 package zxa_assembler
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 )
@@ -11,7 +12,8 @@ import (
 type TokenType int
 
 const (
-	TokenNone TokenType = iota
+	TokenNone TokenType = iota // End of input
+	TokenEOL                   // End of a source line
 	TokenLabel
 	TokenInstruction
 	TokenDirective
@@ -22,8 +24,7 @@ const (
 	TokenColon
 	TokenLParen
 	TokenRParen
-	TokenPlus
-	TokenMinus
+	TokenOperator
 	TokenIdentifier
 )
 
@@ -38,6 +39,7 @@ type Token struct {
 // Parser handles the parsing of assembly source code
 type Parser struct {
 	assembler *Assembler
+	filename  string
 	input     string
 	pos       int
 	line      int
@@ -45,12 +47,22 @@ type Parser struct {
 	tokens    []Token
 	current   int
 	debug     bool
+
+	conditionals []conditional // Open IF blocks, innermost last
+	expansion    bool          // Reading a macro or repeat body
+	ended        bool          // END was read; the rest of the input is ignored
+
+	statementAddr  int    // Address of the statement being parsed, the value of $
+	statementLabel string // Qualified label defined on the statement, if any
+	statementLine  int    // Line of the statement being parsed
+	statementCol   int    // Column where the statement starts
 }
 
-// NewParser creates a new parser instance
+// NewParser creates a new parser instance. CRLF line endings are read as
+// plain newlines.
 func NewParser(input string, debug bool) *Parser {
 	return &Parser{
-		input:   input,
+		input:   strings.ReplaceAll(input, "\r\n", "\n"),
 		pos:     0,
 		line:    1,
 		column:  1,
@@ -67,7 +79,7 @@ func (p *Parser) nextToken() (Token, error) {
 		token := p.tokens[0]
 		p.tokens = p.tokens[1:]
 		if p.debug {
-			fmt.Printf("DEBUG: nextToken: returning buffered token type=%v value='%s'\n", 
+			fmt.Printf("DEBUG: nextToken: returning buffered token type=%v value='%s'\n",
 				token.Type, token.Value)
 		}
 		return token, nil
@@ -75,6 +87,11 @@ func (p *Parser) nextToken() (Token, error) {
 
 	p.skipWhitespace()
 
+	// A comment runs to the end of the line, which still ends the statement
+	if p.pos < len(p.input) && p.input[p.pos] == ';' {
+		p.skipComment()
+	}
+
 	if p.pos >= len(p.input) {
 		if p.debug {
 			fmt.Printf("DEBUG: nextToken: EOF\n")
@@ -89,25 +106,32 @@ func (p *Parser) nextToken() (Token, error) {
 	}
 
 	switch {
-	case c == ';':
-		p.skipComment()
-		return p.nextToken()
-
 	case c == '\n':
+		token := Token{TokenEOL, "", p.line, p.column}
 		p.line++
 		p.column = 1
 		p.pos++
-		return p.nextToken()
+		return token, nil
 
 	case isAlpha(rune(c)):
 		return p.readIdentifier()
 
-	case isDigit(rune(c)) || c == '$' || c == '%':
+	case (c == '.' || c == '@') && p.pos+1 < len(p.input) && isAlpha(rune(p.input[p.pos+1])):
+		// Local label such as .loop, or global name such as @init
+		return p.readIdentifier()
+
+	case isDigit(rune(c)) || c == '$':
+		return p.readNumber()
+
+	case c == '%' && p.pos+1 < len(p.input) && isValidBinaryDigit(p.input[p.pos+1]):
 		return p.readNumber()
 
 	case c == '"':
 		return p.readString()
 
+	case c == '\'':
+		return p.readChar()
+
 	case c == ',':
 		p.pos++
 		p.column++
@@ -128,51 +152,296 @@ func (p *Parser) nextToken() (Token, error) {
 		p.column++
 		return Token{TokenRParen, ")", p.line, p.column - 1}, nil
 
-	case c == '+':
-		p.pos++
-		p.column++
-		return Token{TokenPlus, "+", p.line, p.column - 1}, nil
+	}
 
-	case c == '-':
+	// Expression operators, longest spelling first
+	for _, op := range exprOperators {
+		if strings.HasPrefix(p.input[p.pos:], op) {
+			p.pos += len(op)
+			p.column += len(op)
+			return Token{TokenOperator, op, p.line, p.column - len(op)}, nil
+		}
+	}
+
+	return Token{}, syntaxError(p.filename, p.line, p.column, "unexpected character '%c'", c)
+}
+
+// unread pushes a token back so that nextToken returns it again
+func (p *Parser) unread(token Token) {
+	p.tokens = append([]Token{token}, p.tokens...)
+}
+
+// isEndOfLine reports whether a token ends the statement
+func isEndOfLine(token Token) bool {
+	return token.Type == TokenEOL || token.Type == TokenNone
+}
+
+// readOperands reads the comma separated operands of a statement up to the
+// end of its line. Each operand is rebuilt from its tokens so that a whole
+// expression reaches the evaluator; strings keep their quotes.
+func (p *Parser) readOperands(line int) ([]string, error) {
+	operands := []string{}
+	var current strings.Builder
+	var prev Token
+	depth := 0
+
+	for {
+		tok, err := p.nextToken()
+		if err != nil {
+			return nil, err
+		}
+
+		switch tok.Type {
+		case TokenNone, TokenEOL:
+			// The end of line is left for parseLine to consume
+			p.unread(tok)
+			if depth > 0 {
+				return nil, syntaxError(p.filename, line, tok.Column, "missing closing parenthesis")
+			}
+			if current.Len() == 0 {
+				if len(operands) > 0 {
+					return nil, syntaxError(p.filename, line, tok.Column, "missing operand after comma")
+				}
+				return operands, nil
+			}
+			return append(operands, current.String()), nil
+
+		case TokenComma:
+			if depth == 0 {
+				if current.Len() == 0 {
+					return nil, syntaxError(p.filename, line, tok.Column, "missing operand")
+				}
+				operands = append(operands, current.String())
+				current.Reset()
+				prev = Token{}
+				continue
+			}
+
+		case TokenLParen:
+			depth++
+
+		case TokenRParen:
+			depth--
+			if depth < 0 {
+				return nil, syntaxError(p.filename, line, tok.Column, "unexpected ')'")
+			}
+
+		case TokenRegister, TokenNumber, TokenIdentifier, TokenInstruction,
+			TokenString, TokenOperator:
+
+		default:
+			return nil, syntaxError(p.filename, tok.Line, tok.Column,
+				"unexpected token in operand: %s", tok.Value)
+		}
+
+		// Keep adjacent words such as HIGH table apart
+		if isWordToken(prev) && isWordToken(tok) {
+			current.WriteByte(' ')
+		}
+		if tok.Type == TokenString {
+			current.WriteString("\"" + tok.Value + "\"")
+		} else {
+			current.WriteString(tok.Value)
+		}
+		prev = tok
+	}
+}
+
+// isWordToken reports whether a token is a name or number
+func isWordToken(tok Token) bool {
+	switch tok.Type {
+	case TokenRegister, TokenNumber, TokenIdentifier, TokenInstruction:
+		return true
+	}
+	return false
+}
+
+// parseAll parses every line of the input, returning the number of lines
+// processed. A statement that fails is recorded in the assembler's error
+// list and parsing resumes on the next line, so one run reports them all.
+func (p *Parser) parseAll() int {
+	linesProcessed := 0
+	enclosing := p.assembler.listIndex
+	for !p.isEOF() && !p.ended {
+		p.assembler.listLine(p.filename, p.line, p.sourceLine())
+		n, start := p.assembler.beginStatement()
+		errorsBefore := len(p.assembler.errors.Errors())
+		err := p.parseLine()
+		if err == nil {
+			err = p.assembler.takeMemoryError()
+		}
+		if err != nil {
+			p.assembler.errors.Add(p.diagnostic(err))
+			p.skipLine()
+		}
+		// An error inside a macro or repeat body fails the statement too
+		p.assembler.endStatement(n, start, len(p.assembler.errors.Errors()) > errorsBefore)
+		linesProcessed++
+	}
+	p.checkConditionals()
+	p.assembler.listIndex = enclosing
+	return linesProcessed
+}
+
+// sourceLine returns the text of the line about to be parsed, for the listing
+func (p *Parser) sourceLine() string {
+	end := strings.IndexByte(p.input[p.pos:], '\n')
+	if end < 0 {
+		return p.input[p.pos:]
+	}
+	return p.input[p.pos : p.pos+end]
+}
+
+// diagnostic converts an error from a statement into an AssemblerError,
+// locating errors that carry no position at the failing statement
+func (p *Parser) diagnostic(err error) AssemblerError {
+	var diag AssemblerError
+	if !errors.As(err, &diag) {
+		diag = syntaxError(p.filename, p.statementLine, p.statementCol, "%v", err)
+	}
+	if diag.File == "" {
+		diag.File = p.filename
+	}
+	if diag.Line == 0 {
+		diag.Line = p.statementLine
+	}
+	if diag.Column == 0 && diag.Line == p.statementLine {
+		diag.Column = p.statementCol
+	}
+	if diag.IncludedFrom == nil {
+		diag.IncludedFrom = p.assembler.includeChain()
+	}
+	return diag
+}
+
+// skipLine discards what is left of a line after an error, so parsing
+// resumes with the statement on the following line. The input is skipped
+// character by character, since the rest of the line may not tokenize.
+func (p *Parser) skipLine() {
+	for i, tok := range p.tokens {
+		if isEndOfLine(tok) {
+			p.tokens = p.tokens[i+1:]
+			return
+		}
+	}
+	p.tokens = p.tokens[:0]
+
+	for p.pos < len(p.input) && p.input[p.pos] != '\n' {
 		p.pos++
-		p.column++
-		return Token{TokenMinus, "-", p.line, p.column - 1}, nil
 	}
+	if p.pos < len(p.input) {
+		p.pos++
+		p.line++
+		p.column = 1
+	}
+}
+
+// endLine consumes the end of the current line, rejecting anything left
+// over after a complete statement
+func (p *Parser) endLine() error {
+	token, err := p.nextToken()
+	if err != nil {
+		return err
+	}
+	if !isEndOfLine(token) {
+		p.unread(token)
+		return syntaxError(p.filename, token.Line, token.Column,
+			"unexpected %s at end of statement", token.Value)
+	}
+	return nil
+}
 
-	return Token{}, fmt.Errorf("unexpected character '%c' at line %d, column %d",
-		c, p.line, p.column)
+// defineLabel defines a label at the start of the statement. A label that
+// is not local opens a new scope for the local labels after it, except for
+// the name a label of a macro or repeat body takes in one expansion, which
+// is already unique and leaves the scope of the surrounding code as it is.
+func (p *Parser) defineLabel(label Token) error {
+	local := strings.HasPrefix(label.Value, ".")
+	generated := p.assembler.generated[strings.TrimPrefix(label.Value, ".")]
+	name := p.qualify(label.Value)
+	if local && !generated && p.assembler.currentLabel == "" {
+		return symbolError(p.filename, label.Line, "local label %s has no preceding label", label.Value)
+	}
+	if err := p.assembler.addSymbol(name, p.assembler.logicalAddr()); err != nil {
+		return symbolError(p.filename, label.Line, "%v", err)
+	}
+	if !local && !generated {
+		p.assembler.currentLabel = name
+	}
+	p.statementLabel = name
+	return nil
 }
 
-// parseLine parses a single line of assembly
+// parseLine parses a single line of assembly, including its end of line
 func (p *Parser) parseLine() error {
+	if !p.active() {
+		return p.skipInactiveLine()
+	}
+	if err := p.parseStatement(); err != nil {
+		return err
+	}
+	return p.endLine()
+}
+
+// parseStatement parses the label and statement of a line, leaving the end
+// of line unread
+func (p *Parser) parseStatement() error {
+	p.statementAddr = p.assembler.logicalAddr()
+	p.statementLine, p.statementCol = p.line, 0
+	p.statementLabel = ""
+
 	token, err := p.nextToken()
 	if err != nil {
 		return err
 	}
+	p.statementLine, p.statementCol = token.Line, token.Column
 
 	if p.debug {
-		fmt.Printf("DEBUG: parseLine: first token type=%v value='%s'\n", 
+		fmt.Printf("DEBUG: parseLine: first token type=%v value='%s'\n",
 			token.Type, token.Value)
 	}
 
 	// Empty line or end of file
-	if token.Type == TokenNone {
+	if isEndOfLine(token) {
+		p.unread(token)
 		return nil
 	}
 
+	// Anonymous numeric label such as "1:"
+	if token.Type == TokenNumber && isDecimal(token.Value) {
+		next, err := p.nextToken()
+		if err != nil {
+			return err
+		}
+		if next.Type == TokenColon {
+			if err := p.assembler.defineAnonymous(token.Value); err != nil {
+				return symbolError(p.filename, token.Line, "%v", err)
+			}
+			if token, err = p.nextToken(); err != nil {
+				return err
+			}
+			if isEndOfLine(token) {
+				p.unread(token)
+				return nil
+			}
+		} else {
+			p.unread(next)
+		}
+	}
+
 	// Handle label definitions
 	if token.Type == TokenIdentifier {
 		if p.debug {
 			fmt.Printf("DEBUG: Processing identifier '%s'\n", token.Value)
 		}
-		
+
 		nextToken, err := p.nextToken()
 		if err != nil {
 			return err
 		}
 
 		if p.debug {
-			fmt.Printf("DEBUG: After identifier, next token type=%v value='%s'\n", 
+			fmt.Printf("DEBUG: After identifier, next token type=%v value='%s'\n",
 				nextToken.Type, nextToken.Value)
 		}
 
@@ -183,29 +452,50 @@ func (p *Parser) parseLine() error {
 				fmt.Printf("DEBUG: Found label definition '%s:'\n",
 					token.Value)
 			}
-			if err := p.assembler.addSymbol(token.Value, p.assembler.currentAddr); err != nil {
-				return err
-			}
-			// Get next token for instruction processing
+			label := token
 			token, err = p.nextToken()
 			if err != nil {
 				return err
 			}
 
+			// "LABEL: EQU value" names a constant rather than an address
+			if token.Type == TokenDirective && strings.ToUpper(token.Value) == "EQU" {
+				return p.parseEQU(p.qualify(label.Value), token.Line)
+			}
+			if err := p.defineLabel(label); err != nil {
+				return err
+			}
+
+			// Get next token for instruction processing
+			if isEndOfLine(token) {
+				p.unread(token)
+				return nil
+			}
+
 		case TokenDirective:
-			// Handle case like "LABEL EQU value"
-			if strings.ToUpper(nextToken.Value) == "EQU" {
-				// Save the label for EQU processing
-				p.assembler.currentLabel = token.Value
-				return p.parseDirective(nextToken)
+			// Handle case like "LABEL EQU value" or "NAME DEFL value"
+			switch strings.ToUpper(nextToken.Value) {
+			case "EQU":
+				return p.parseEQU(p.qualify(token.Value), nextToken.Line)
+			case "DEFL":
+				return p.parseSET(p.qualify(token.Value), "DEFL", nextToken.Line)
 			}
 			// Not EQU, treat as normal identifier
-			p.tokens = append(p.tokens, nextToken)
+			p.unread(nextToken)
+			token = Token{TokenIdentifier, token.Value, token.Line, token.Column}
+
+		case TokenInstruction, TokenOperator:
+			// "NAME SET value" and "NAME = value"; SET b,r never follows a
+			// label without a colon
+			if directive := strings.ToUpper(nextToken.Value); directive == "SET" || directive == "=" {
+				return p.parseSET(p.qualify(token.Value), directive, nextToken.Line)
+			}
+			p.unread(nextToken)
 			token = Token{TokenIdentifier, token.Value, token.Line, token.Column}
 
 		default:
-			// Not a label definition, put back both tokens
-			p.tokens = append(p.tokens, nextToken)
+			// Not a label definition, put back the second token
+			p.unread(nextToken)
 			token = Token{TokenIdentifier, token.Value, token.Line, token.Column}
 		}
 	}
@@ -216,14 +506,29 @@ func (p *Parser) parseLine() error {
 			token.Type, token.Value)
 	}
 
+	// A macro may share its name with an instruction and takes precedence
+	if token.Type == TokenIdentifier || token.Type == TokenInstruction {
+		if macro, ok := p.lookupMacro(token.Value); ok {
+			return p.expandMacro(macro, token)
+		}
+	}
+	if token.Type == TokenIdentifier {
+		if s, ok := p.lookupStruct(token.Value); ok {
+			return p.instantiate(s, token)
+		}
+		if isStatementDirective(token.Value) {
+			token.Type = TokenDirective
+		}
+	}
+
 	switch token.Type {
 	case TokenInstruction:
 		return p.parseInstruction(token)
 	case TokenDirective:
 		return p.parseDirective(token)
-	case TokenNone:
-		return nil
+	case TokenIdentifier:
+		return syntaxError(p.filename, token.Line, token.Column, "unknown instruction or directive: %s", token.Value)
 	default:
-		return fmt.Errorf("unexpected token at line %d: %s", token.Line, token.Value)
+		return syntaxError(p.filename, token.Line, token.Column, "unexpected token: %s", token.Value)
 	}
-}
\ No newline at end of file
+}
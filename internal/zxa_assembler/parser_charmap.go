@@ -0,0 +1,183 @@
+// file: internal/zxa_assembler/parser_charmap.go
+
+package zxa_assembler
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// defaultCharmap names the character set in use until CHARMAP selects
+// another: ASCII, with every other character refused
+const defaultCharmap = "ascii"
+
+// charmap translates source characters to the bytes string data is
+// stored as. A character constant in an expression keeps its code point.
+type charmap struct {
+	name  string
+	codes map[rune]byte
+}
+
+// newCharmap returns an empty charmap, or the built-in ASCII one
+func newCharmap(name string) *charmap {
+	m := &charmap{name: name, codes: make(map[rune]byte)}
+	if strings.EqualFold(name, defaultCharmap) {
+		for r := rune(0); r < 0x80; r++ {
+			m.codes[r] = byte(r)
+		}
+	}
+	return m
+}
+
+// resetCharmaps leaves only the built-in charmap, selected
+func (a *Assembler) resetCharmaps() {
+	a.charmap = newCharmap(defaultCharmap)
+	a.charmaps = map[string]*charmap{defaultCharmap: a.charmap}
+}
+
+// encodeChar returns the byte a character is stored as in the current
+// charmap. Raw \xHH bytes are stored as written.
+func (a *Assembler) encodeChar(c textChar) (byte, error) {
+	if c.raw {
+		return byte(c.value), nil
+	}
+	b, ok := a.charmap.codes[c.value]
+	if !ok {
+		return 0, fmt.Errorf("character %q (U+%04X) is not in charmap %s", c.value, c.value, a.charmap.name)
+	}
+	return b, nil
+}
+
+// parseCHARMAP handles the forms of the CHARMAP directive:
+//
+//	CHARMAP "chars", code    map chars to code, code+1, ... in the current map
+//	CHARMAP name             switch to a map, creating an empty one if new
+//	CHARMAP name, base       create a map as a copy of base and switch to it
+//	CHARMAP name, "file"     create a map from a file of "chars", code lines
+//
+// The built-in map is called ascii.
+func (p *Parser) parseCHARMAP(line int) error {
+	operands, err := p.readOperands(line)
+	if err != nil {
+		return err
+	}
+	if len(operands) < 1 || len(operands) > 2 {
+		return directiveError(p.filename, line, "CHARMAP requires a map name or characters and a code")
+	}
+
+	a := p.assembler
+	if _, ok := stringOperand(operands[0]); ok || strings.HasPrefix(operands[0], "'") {
+		if len(operands) != 2 {
+			return directiveError(p.filename, line, "CHARMAP requires a code for %s", operands[0])
+		}
+		return p.addCharCodes(a.charmap, operands[0], operands[1], line)
+	}
+
+	name := operands[0]
+	if !isSymbolName(name) {
+		return directiveError(p.filename, line, "invalid charmap name: %s", name)
+	}
+	key := strings.ToLower(name)
+
+	if len(operands) == 1 {
+		if _, exists := a.charmaps[key]; !exists {
+			a.charmaps[key] = newCharmap(name)
+		}
+		a.charmap = a.charmaps[key]
+		return nil
+	}
+
+	if _, exists := a.charmaps[key]; exists {
+		return directiveError(p.filename, line, "charmap %s already defined", name)
+	}
+	m := newCharmap(name)
+	if filename, ok := stringOperand(operands[1]); ok {
+		if err := p.loadCharmap(m, filename, line); err != nil {
+			return err
+		}
+	} else {
+		base, exists := a.charmaps[strings.ToLower(operands[1])]
+		if !exists {
+			return directiveError(p.filename, line, "unknown charmap: %s", operands[1])
+		}
+		for r, b := range base.codes {
+			m.codes[r] = b
+		}
+	}
+	a.charmaps[key] = m
+	a.charmap = m
+	return nil
+}
+
+// addCharCodes maps the characters of a string or character constant to
+// consecutive codes starting at the value of code
+func (p *Parser) addCharCodes(m *charmap, chars, code string, line int) error {
+	var decoded []textChar
+	if str, ok := stringOperand(chars); ok {
+		var err error
+		if decoded, err = decodeString(str); err != nil {
+			return valueError(p.filename, line, "%v in string \"%s\"", err, str)
+		}
+	} else {
+		c, err := charLiteral(chars)
+		if err != nil {
+			return valueError(p.filename, line, "%v", err)
+		}
+		decoded = []textChar{c}
+	}
+	if len(decoded) == 0 {
+		return directiveError(p.filename, line, "CHARMAP requires at least one character")
+	}
+
+	value, err := p.evaluateResolved(code)
+	if err != nil {
+		return err
+	}
+	if value < 0 || value+len(decoded)-1 > 0xFF {
+		return valueError(p.filename, line, "CHARMAP codes out of range: %d to %d", value, value+len(decoded)-1)
+	}
+
+	for i, c := range decoded {
+		if c.raw {
+			return valueError(p.filename, line, "CHARMAP maps characters, not raw bytes: \\x%02X", c.value)
+		}
+		m.codes[c.value] = byte(value + i)
+	}
+	return nil
+}
+
+// loadCharmap reads a charmap file. Each line holds the operands of the
+// CHARMAP "chars", code form, and may have a comment.
+func (p *Parser) loadCharmap(m *charmap, filename string, line int) error {
+	path, err := p.assembler.findFile(filename, SourceLocation{File: p.filename, Line: line}, "charmap")
+	if err != nil {
+		return fileError(p.filename, line, "%v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fileError(p.filename, line, "failed to read charmap file %s: %v", path, err)
+	}
+
+	parser := NewParser(string(content), p.debug)
+	parser.assembler = p.assembler
+	parser.filename = path
+	for !parser.isEOF() {
+		n := parser.line
+		parser.statementLine = n
+		operands, err := parser.readOperands(n)
+		if err == nil && len(operands) != 0 && len(operands) != 2 {
+			err = directiveError(path, n, "charmap entries are written \"chars\", code")
+		}
+		if err == nil && len(operands) == 2 {
+			err = parser.addCharCodes(m, operands[0], operands[1], n)
+		}
+		if err == nil {
+			err = parser.endLine()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
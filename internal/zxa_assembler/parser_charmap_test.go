@@ -0,0 +1,56 @@
+// file: internal/zxa_assembler/parser_charmap_test.go
+
+package zxa_assembler
+
+import "testing"
+
+func TestCHARMAP(t *testing.T) {
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{
+			name: "map characters in the current map",
+			src:  " CHARMAP \"£\", $60\n DB \"£1\"\n",
+			want: []byte{0x60, '1'},
+		},
+		{
+			name: "character constants keep their code",
+			src:  " CHARMAP \"A\", 0\n ld a, 'A'\n cp 'A'+1\n DB \"A\", 'A'\n",
+			want: []byte{0x3E, 'A', 0xFE, 'B', 0x00, 'A'},
+		},
+		{
+			name: "consecutive codes",
+			src:  " CHARMAP screen\n CHARMAP \"ABC\", 1\n DB \"CAB\"\n",
+			want: []byte{3, 1, 2},
+		},
+		{
+			name: "copy of a map",
+			src:  " CHARMAP upper, ascii\n CHARMAP \"a\", 'A'\n DB \"ab\"\n CHARMAP ascii\n DB \"a\"\n",
+			want: []byte{'A', 'b', 'a'},
+		},
+		{
+			name: "switching back keeps a map",
+			src:  " CHARMAP m\n CHARMAP \"x\", 9\n CHARMAP ascii\n CHARMAP m\n DB \"x\"\n",
+			want: []byte{9},
+		},
+		{
+			name: "raw bytes are not mapped",
+			src:  " CHARMAP m\n DB \"\\x41\"\n",
+			want: []byte{0x41},
+		},
+		{name: "character not in map", src: " CHARMAP m\n DB \"a\"\n", wantErr: "character 'a' (U+0061) is not in charmap m"},
+		{name: "unknown base", src: " CHARMAP m, nothing\n", wantErr: "unknown charmap: nothing"},
+		{name: "defined twice", src: " CHARMAP m, ascii\n CHARMAP m, ascii\n", wantErr: "charmap m already defined"},
+		{name: "codes out of range", src: " CHARMAP \"ab\", 255\n", wantErr: "CHARMAP codes out of range: 255 to 256"},
+	})
+}
+
+func TestCHARMAPFile(t *testing.T) {
+	files := map[string]string{
+		"zx.map": "; ZX Spectrum\n\"£\", $60\n\"©\", $7F ; copyright\n\"AB\", 'a'\n",
+	}
+	_, result, err := assembleFiles(t, AssemblerOptions{}, " CHARMAP zx, \"zx.map\"\n DB \"£©BA\"\n", files)
+	checkResult(t, result, err, []byte{0x60, 0x7F, 'b', 'a'}, "")
+
+	files["bad.map"] = "\"a\"\n"
+	_, result, err = assembleFiles(t, AssemblerOptions{}, " CHARMAP bad, \"bad.map\"\n", files)
+	checkResult(t, result, err, nil, "charmap entries are written \"chars\", code")
+}
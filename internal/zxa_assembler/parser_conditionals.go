@@ -0,0 +1,185 @@
+// file: internal/zxa_assembler/parser_conditionals.go
+
+package zxa_assembler
+
+import "strings"
+
+// conditional tracks one IF ... ENDIF block
+type conditional struct {
+	line     int  // Line of the opening IF, for unterminated blocks
+	active   bool // Lines of the current branch are assembled
+	taken    bool // A branch has been assembled, or the whole block is skipped
+	seenElse bool
+}
+
+// isConditional reports whether a directive opens, continues or closes a
+// conditional block
+func isConditional(directive string) bool {
+	switch strings.ToUpper(directive) {
+	case "IF", "IFDEF", "IFNDEF", "ELSEIF", "ELSE", "ENDIF":
+		return true
+	}
+	return false
+}
+
+// active reports whether lines are currently being assembled
+func (p *Parser) active() bool {
+	return len(p.conditionals) == 0 || p.conditionals[len(p.conditionals)-1].active
+}
+
+// skipInactiveLine passes over a line inside a branch that is not
+// assembled. Only conditional directives are looked at, so the rest of the
+// line is never encoded and need not even be valid.
+func (p *Parser) skipInactiveLine() error {
+	p.statementLine, p.statementCol = p.line, 0
+
+	token, err := p.nextToken()
+	if err != nil {
+		p.skipLine()
+		return nil
+	}
+	if isEndOfLine(token) {
+		return nil
+	}
+
+	// A label may stand before the directive
+	if token.Type == TokenIdentifier {
+		next, err := p.nextToken()
+		if err != nil || next.Type != TokenColon {
+			if err == nil {
+				p.unread(next)
+			}
+			p.skipLine()
+			return nil
+		}
+		if token, err = p.nextToken(); err != nil {
+			p.skipLine()
+			return nil
+		}
+	}
+
+	if token.Type != TokenDirective || !isConditional(token.Value) {
+		p.unread(token)
+		p.skipLine()
+		return nil
+	}
+
+	p.statementLine, p.statementCol = token.Line, token.Column
+	if err := p.parseConditional(token); err != nil {
+		return err
+	}
+	return p.endLine()
+}
+
+// parseConditional handles IF, IFDEF, IFNDEF, ELSEIF, ELSE and ENDIF
+func (p *Parser) parseConditional(token Token) error {
+	directive := strings.ToUpper(token.Value)
+	line := token.Line
+
+	switch directive {
+	case "IF", "IFDEF", "IFNDEF":
+		// Inside a skipped branch the condition is not even evaluated
+		if !p.active() {
+			p.conditionals = append(p.conditionals, conditional{line: line, taken: true})
+			p.discardOperands()
+			return nil
+		}
+		cond, err := p.evaluateCondition(directive, line)
+		if err != nil {
+			p.conditionals = append(p.conditionals, conditional{line: line, taken: true})
+			return err
+		}
+		p.conditionals = append(p.conditionals, conditional{line: line, active: cond, taken: cond})
+		return nil
+	}
+
+	if len(p.conditionals) == 0 {
+		p.discardOperands()
+		return directiveError(p.filename, line, "%s without IF", directive)
+	}
+	block := &p.conditionals[len(p.conditionals)-1]
+
+	switch directive {
+	case "ELSEIF":
+		if block.seenElse {
+			p.discardOperands()
+			return directiveError(p.filename, line, "ELSEIF after ELSE (IF at line %d)", block.line)
+		}
+		if block.taken {
+			block.active = false
+			p.discardOperands()
+			return nil
+		}
+		cond, err := p.evaluateCondition("IF", line)
+		if err != nil {
+			block.active, block.taken = false, true
+			return err
+		}
+		block.active, block.taken = cond, cond
+
+	case "ELSE":
+		if block.seenElse {
+			return directiveError(p.filename, line, "ELSE after ELSE (IF at line %d)", block.line)
+		}
+		block.active, block.taken, block.seenElse = !block.taken, true, true
+
+	case "ENDIF":
+		p.conditionals = p.conditionals[:len(p.conditionals)-1]
+	}
+
+	return nil
+}
+
+// evaluateCondition reads and evaluates the operand of an IF or IFDEF.
+// The result decides which lines exist, so forward references are refused
+// rather than letting the two passes see different code.
+func (p *Parser) evaluateCondition(directive string, line int) (bool, error) {
+	operands, err := p.readOperands(line)
+	if err != nil {
+		return false, err
+	}
+	if len(operands) != 1 {
+		return false, directiveError(p.filename, line, "%s requires one operand", directive)
+	}
+
+	if directive == "IF" {
+		value, err := p.evaluateResolved(operands[0])
+		if err != nil {
+			return false, err
+		}
+		return value != 0, nil
+	}
+
+	name := operands[0]
+	if !isLabelName(name) {
+		return false, directiveError(p.filename, line, "%s requires a symbol name: %s", directive, name)
+	}
+	defined := p.isSymbolDefined(name)
+	if directive == "IFNDEF" {
+		return !defined, nil
+	}
+	return defined, nil
+}
+
+// discardOperands drops the rest of the line up to its end, without
+// tokenizing it
+func (p *Parser) discardOperands() {
+	for i, tok := range p.tokens {
+		if isEndOfLine(tok) {
+			p.tokens = p.tokens[i:]
+			return
+		}
+	}
+	p.tokens = p.tokens[:0]
+	for p.pos < len(p.input) && p.input[p.pos] != '\n' {
+		p.pos++
+	}
+}
+
+// checkConditionals reports blocks still open at the end of the file
+func (p *Parser) checkConditionals() {
+	for _, block := range p.conditionals {
+		p.assembler.errors.Add(p.diagnostic(directiveError(p.filename, block.line, "IF without ENDIF")))
+	}
+	p.conditionals = nil
+}
@@ -0,0 +1,81 @@
+// file: internal/zxa_assembler/parser_conditionals_test.go
+
+package zxa_assembler
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestConditionals(t *testing.T) {
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{
+			name: "IF true",
+			src:  " IF 1\n DEFB 1\n ENDIF\n",
+			want: []byte{1},
+		},
+		{
+			name: "IF false with ELSE",
+			src:  " IF 0\n DEFB 1\n ELSE\n DEFB 2\n ENDIF\n",
+			want: []byte{2},
+		},
+		{
+			name: "ELSEIF chain takes the first true branch",
+			src:  "v EQU 2\n IF v == 1\n DEFB 1\n ELSEIF v == 2\n DEFB 2\n ELSEIF v >= 2\n DEFB 3\n ELSE\n DEFB 4\n ENDIF\n",
+			want: []byte{2},
+		},
+		{
+			name: "nested",
+			src:  " IF 1\n IF 0\n DEFB 1\n ELSE\n DEFB 2\n ENDIF\n DEFB 3\n ENDIF\n",
+			want: []byte{2, 3},
+		},
+		{
+			name: "skipped lines are not parsed",
+			src:  " IF 0\n this is not assembly\n ENDIF\n nop\n",
+			want: []byte{0},
+		},
+		{
+			name: "IFDEF and IFNDEF",
+			src:  "have EQU 1\n IFDEF have\n DEFB 1\n ENDIF\n IFNDEF have\n DEFB 2\n ENDIF\n IFNDEF lacking\n DEFB 3\n ENDIF\n",
+			want: []byte{1, 3},
+		},
+		{name: "ENDIF without IF", src: " ENDIF\n", wantErr: "ENDIF without IF"},
+		{name: "ELSE after ELSE", src: " IF 1\n ELSE\n ELSE\n ENDIF\n", wantErr: "ELSE"},
+		{name: "unclosed IF", src: " IF 1\n nop\n", wantErr: "IF without ENDIF"},
+		{name: "forward reference in IF", src: " IF later\n nop\n ENDIF\nlater EQU 1\n", wantErr: "later"},
+	})
+}
+
+func TestDefine(t *testing.T) {
+	tests := []struct {
+		name, value string
+		want        []byte
+	}{
+		{"DEBUG", "", []byte{1, 1}},
+		{"DEBUG", "7", []byte{1, 7}},
+		{"OTHER", "", []byte{0, 0}},
+	}
+	src := " IFDEF DEBUG\n DEFB 1, DEBUG\n ELSE\n DEFB 0, 0\n ENDIF\n"
+	for _, tc := range tests {
+		t.Run(tc.name+"="+tc.value, func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{"main.asm": src})
+			a := NewAssembler(AssemblerOptions{})
+			if err := a.Define(tc.name, tc.value); err != nil {
+				t.Fatal(err)
+			}
+			result, err := a.Assemble(filepath.Join(dir, "main.asm"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(result.Binary, tc.want) {
+				t.Fatalf("got % X, want % X", result.Binary, tc.want)
+			}
+		})
+	}
+
+	a := NewAssembler(AssemblerOptions{})
+	if err := a.Define("1bad", ""); err == nil {
+		t.Error("expected an error for an invalid name")
+	}
+}
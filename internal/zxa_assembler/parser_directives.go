@@ -3,51 +3,121 @@
 package zxa_assembler
 
 import (
-	"fmt"
+	"os"
 	"strings"
 )
 
+// directiveAliases maps other spellings of data directives to the name
+// they are handled under
+var directiveAliases = map[string]string{
+	"DB":   "DEFB",
+	"DEFM": "DEFB",
+	"DW":   "DEFW",
+	"DS":   "DEFS",
+	"DISP": "PHASE",
+	"ENT":  "DEPHASE",
+}
+
+// canonicalDirective returns the upper case name a directive is handled under
+func canonicalDirective(name string) string {
+	name = strings.ToUpper(name)
+	if canonical, ok := directiveAliases[name]; ok {
+		return canonical
+	}
+	return name
+}
+
 // parseDirective handles the parsing of assembler directives
 func (p *Parser) parseDirective(token Token) error {
-	directive := strings.ToUpper(token.Value)
+	directive := canonicalDirective(token.Value)
+
+	if isConditional(directive) {
+		return p.parseConditional(token)
+	}
 
 	switch directive {
 	case "ORG":
-		return p.parseORG()
-	case "EQU":
-		return p.parseEQU()
-	case "DEFB":
-		return p.parseDEFB()
+		return p.parseORG(token.Line)
+	case "EQU", "DEFL":
+		return directiveError(p.filename, token.Line, "%s without label", directive)
+	case "DEFB", "DZ", "DC":
+		return p.parseDEFB(directive, token.Line)
 	case "DEFW":
-		return p.parseDEFW()
+		return p.parseDEFW(token.Line)
+	case "DD":
+		return p.parseDD(token.Line)
 	case "DEFS":
-		return p.parseDEFS()
+		return p.parseDEFS(token.Line)
+	case "PHASE":
+		return p.parsePHASE(token.Line)
+	case "DEPHASE":
+		return p.parseDEPHASE(token.Line)
+	case "CHARMAP":
+		return p.parseCHARMAP(token.Line)
+	case "ALIGN":
+		return p.parseALIGN(token.Line)
+	case "ASSERT":
+		return p.parseASSERT(token.Line)
+	case "DISPLAY", "WARNING", "ERROR":
+		return p.parseMessage(directive, token.Line)
 	case "INCLUDE":
-		return p.parseINCLUDE()
+		return p.parseINCLUDE(token.Line)
 	case "INCBIN":
-		return p.parseINCBIN()
+		return p.parseINCBIN(token.Line)
+	case "END":
+		return p.parseEND(token.Line)
+	case "SAVEBIN", "SAVETAP", "SAVESNA", "SAVENEX":
+		return p.parseSAVE(directive, token.Line)
+	case "MACRO":
+		return p.parseMACRO(token.Line)
+	case "ENDM":
+		return directiveError(p.filename, token.Line, "ENDM without MACRO")
+	case "LOCAL":
+		return directiveError(p.filename, token.Line, "LOCAL is only allowed inside a macro")
+	case "REPT", "DUP":
+		return p.parseREPT(directive, token.Line)
+	case "WHILE":
+		return p.parseWHILE(token.Line)
+	case "ENDR", "EDUP", "ENDW":
+		return directiveError(p.filename, token.Line, "%s without %s", directive,
+			repeatOpens[indexString(repeatCloses, directive)])
+	case "MODULE":
+		return p.parseMODULE(token.Line)
+	case "ENDMODULE":
+		return p.parseENDMODULE(token.Line)
+	case "STRUCT":
+		return p.parseSTRUCT(token.Line)
+	case "ENDS":
+		return directiveError(p.filename, token.Line, "ENDS without STRUCT")
 	default:
-		return fmt.Errorf("unknown directive at line %d: %s",
-			token.Line, directive)
+		return directiveError(p.filename, token.Line, "unknown directive: %s", directive)
 	}
 }
 
 // parseORG handles the ORG directive
-func (p *Parser) parseORG() error {
+func (p *Parser) parseORG(line int) error {
 	// Get the address expression
-	token, err := p.nextToken()
+	operands, err := p.readOperands(line)
 	if err != nil {
 		return err
 	}
 
-	if token.Type != TokenNumber && token.Type != TokenIdentifier {
-		return fmt.Errorf("ORG requires address at line %d", token.Line)
+	if len(operands) != 1 {
+		return directiveError(p.filename, line, "ORG requires address")
 	}
 
-	// Evaluate the address
-	addr, err := p.evaluateExpression(token.Value)
+	// Evaluate the address; it decides where pass 1 places labels
+	addr, err := p.evaluateResolved(operands[0])
 	if err != nil {
-		return fmt.Errorf("invalid ORG address at line %d: %v", token.Line, err)
+		return err
+	}
+	if addr < 0 || addr >= memorySize {
+		return valueError(p.filename, line, "ORG address out of range: %d", addr)
+	}
+
+	// Inside PHASE the logical address would become ambiguous
+	if p.assembler.phase != nil {
+		return directiveError(p.filename, line, "ORG inside PHASE (opened at %s)", p.assembler.phase)
 	}
 
 	// Set the current address
@@ -56,117 +126,203 @@ func (p *Parser) parseORG() error {
 	return nil
 }
 
-// parseEQU handles the EQU directive
-func (p *Parser) parseEQU() error {
-	// Get the value expression
-	token, err := p.nextToken()
+// parsePHASE handles PHASE addr, also written DISP. Up to DEPHASE, labels
+// and $ take addresses from addr on while the bytes are still stored at
+// the current address, for code that is copied elsewhere to run.
+func (p *Parser) parsePHASE(line int) error {
+	operands, err := p.readOperands(line)
 	if err != nil {
 		return err
 	}
 
-	if token.Type != TokenNumber && token.Type != TokenIdentifier {
-		return fmt.Errorf("EQU requires value at line %d", token.Line)
+	if len(operands) != 1 {
+		return directiveError(p.filename, line, "PHASE requires address")
+	}
+	a := p.assembler
+	if a.phase != nil {
+		return directiveError(p.filename, line, "PHASE inside PHASE (opened at %s)", a.phase)
 	}
 
-	// Evaluate the value
-	value, err := p.evaluateExpression(token.Value)
+	addr, err := p.evaluateResolved(operands[0])
 	if err != nil {
-		return fmt.Errorf("invalid EQU value at line %d: %v", token.Line, err)
+		return err
 	}
-
-	// Either use the current label or the last seen label
-	if p.assembler.currentLabel == "" {
-		return fmt.Errorf("EQU without label at line %d", token.Line)
+	if addr < 0 || addr >= memorySize {
+		return valueError(p.filename, line, "PHASE address out of range: %d", addr)
 	}
 
-	// Add or update the symbol
-	p.assembler.symbols[p.assembler.currentLabel] = Symbol{
-		Name:  p.assembler.currentLabel,
-		Value: value,
-		Type:  "equ",
+	a.phase = &SourceLocation{File: p.filename, Line: line}
+	a.phaseOffset = addr - a.currentAddr
+	return nil
+}
+
+// parseDEPHASE handles DEPHASE, also written ENT, which returns to
+// assembling for the address the code is stored at
+func (p *Parser) parseDEPHASE(line int) error {
+	a := p.assembler
+	if a.phase == nil {
+		return directiveError(p.filename, line, "DEPHASE without PHASE")
 	}
+	a.phase = nil
+	a.phaseOffset = 0
+	return nil
+}
 
-	// Clear the current label
-	p.assembler.currentLabel = ""
+// parseEQU handles the EQU directive, defining the label written before it.
+// An EQU constant may be repeated with the same value but never changed.
+func (p *Parser) parseEQU(name string, line int) error {
+	return p.assign(name, "equ", "EQU", line)
+}
 
-	return nil
+// parseSET handles NAME SET value, also written DEFL or =, which defines a
+// variable that later SETs may change
+func (p *Parser) parseSET(name, directive string, line int) error {
+	return p.assign(name, "set", directive, line)
 }
 
-// parseDEFB handles the DEFB directive
-func (p *Parser) parseDEFB() error {
-	for {
-		token, err := p.nextToken()
-		if err != nil {
-			return err
+// assign evaluates the value of an EQU or SET and gives it to the symbol
+func (p *Parser) assign(name, kind, directive string, line int) error {
+	// Get the value expression
+	operands, err := p.readOperands(line)
+	if err != nil {
+		return err
+	}
+
+	if len(operands) != 1 {
+		return directiveError(p.filename, line, "%s requires value", directive)
+	}
+
+	// Evaluate the value
+	value, undefined, err := p.evaluate(operands[0])
+	if err != nil {
+		return err
+	}
+
+	// Only a symbol of the same kind may be given a value again
+	a := p.assembler
+	if sym, exists := a.symbols[name]; exists && a.isDefined(name) {
+		if sym.Type != kind {
+			return symbolError(p.filename, line, "%s is already defined as %s", name, symbolKind(sym.Type))
+		}
+		if kind == "equ" && undefined == "" && sym.Value != value {
+			return symbolError(p.filename, line, "EQU %s redefined from %d to %d (use SET or DEFL for a value that changes)",
+				name, sym.Value, value)
 		}
+	}
 
-		if token.Type == TokenNone {
-			break
+	// A value built on a forward reference is only defined in the final pass
+	a.defined[name] = true
+	if undefined != "" {
+		if a.pass == finalPass {
+			return symbolError(p.filename, line, "undefined symbol in %s: %s", directive, undefined)
 		}
+		return nil
+	}
 
-		switch token.Type {
-		case TokenString:
-			// Emit each character as a byte
-			for _, c := range token.Value {
-				p.assembler.emitByte(byte(c))
-			}
+	// Add or update the symbol
+	a.setConstant(name, value, kind)
+
+	return nil
+}
 
-		case TokenNumber, TokenIdentifier:
-			// Evaluate and emit the byte value
-			value, err := p.evaluateExpression(token.Value)
+// parseDEFB handles DEFB and its variants: DZ adds a zero after the data
+// and DC sets bit 7 of the last character of each string, as the Spectrum
+// ROM marks the end of its messages
+func (p *Parser) parseDEFB(directive string, line int) error {
+	operands, err := p.readOperands(line)
+	if err != nil {
+		return err
+	}
+
+	for _, op := range operands {
+		// Emit each character of a string as a byte
+		if str, ok := stringOperand(op); ok {
+			data, err := p.stringBytes(str, line)
 			if err != nil {
 				return err
 			}
-			if value < -128 || value > 255 {
-				return fmt.Errorf("DEFB value out of range at line %d: %d",
-					token.Line, value)
+			if directive == "DC" && len(data) > 0 {
+				data[len(data)-1] |= 0x80
+			}
+			for _, b := range data {
+				p.assembler.emitByte(b)
 			}
-			p.assembler.emitByte(byte(value))
-
-		case TokenComma:
 			continue
+		}
 
-		default:
-			return fmt.Errorf("unexpected token in DEFB at line %d: %s",
-				token.Line, token.Value)
+		// Evaluate and emit the byte value
+		value, err := p.evaluateExpression(op)
+		if err != nil {
+			return err
+		}
+		if value < -128 || value > 255 {
+			return valueError(p.filename, line, "%s value out of range: %d", directive, value)
 		}
+		p.assembler.emitByte(byte(value))
 	}
 
+	if directive == "DZ" {
+		p.assembler.emitByte(0)
+	}
 	return nil
 }
 
+// stringBytes decodes a string operand into the bytes it assembles to,
+// translating each character through the current charmap
+func (p *Parser) stringBytes(str string, line int) ([]byte, error) {
+	chars, err := decodeString(str)
+	if err != nil {
+		return nil, valueError(p.filename, line, "%v in string \"%s\"", err, str)
+	}
+	data := make([]byte, len(chars))
+	for i, c := range chars {
+		if data[i], err = p.assembler.encodeChar(c); err != nil {
+			return nil, valueError(p.filename, line, "%v", err)
+		}
+	}
+	return data, nil
+}
+
 // parseDEFW handles the DEFW directive
-func (p *Parser) parseDEFW() error {
-	for {
-		token, err := p.nextToken()
+func (p *Parser) parseDEFW(line int) error {
+	operands, err := p.readOperands(line)
+	if err != nil {
+		return err
+	}
+
+	for _, op := range operands {
+		// Evaluate and emit the word value
+		value, err := p.evaluateExpression(op)
 		if err != nil {
 			return err
 		}
-
-		if token.Type == TokenNone {
-			break
+		if value < -32768 || value > 65535 {
+			return valueError(p.filename, line, "DEFW value out of range: %d", value)
 		}
+		p.assembler.emitByte(byte(value))
+		p.assembler.emitByte(byte(value >> 8))
+	}
 
-		switch token.Type {
-		case TokenNumber, TokenIdentifier:
-			// Evaluate and emit the word value
-			value, err := p.evaluateExpression(token.Value)
-			if err != nil {
-				return err
-			}
-			if value < -32768 || value > 65535 {
-				return fmt.Errorf("DEFW value out of range at line %d: %d",
-					token.Line, value)
-			}
-			p.assembler.emitByte(byte(value))
-			p.assembler.emitByte(byte(value >> 8))
+	return nil
+}
 
-		case TokenComma:
-			continue
+// parseDD handles the DD directive, emitting 32-bit values low byte first
+func (p *Parser) parseDD(line int) error {
+	operands, err := p.readOperands(line)
+	if err != nil {
+		return err
+	}
 
-		default:
-			return fmt.Errorf("unexpected token in DEFW at line %d: %s",
-				token.Line, token.Value)
+	for _, op := range operands {
+		value, err := p.evaluateExpression(op)
+		if err != nil {
+			return err
+		}
+		if v := int64(value); v < -1<<31 || v > 1<<32-1 {
+			return valueError(p.filename, line, "DD value out of range: %d", value)
+		}
+		for i := 0; i < 4; i++ {
+			p.assembler.emitByte(byte(value >> (8 * i)))
 		}
 	}
 
@@ -174,45 +330,79 @@ func (p *Parser) parseDEFW() error {
 }
 
 // parseDEFS handles the DEFS directive
-func (p *Parser) parseDEFS() error {
-	// Get size
-	token, err := p.nextToken()
+func (p *Parser) parseDEFS(line int) error {
+	operands, err := p.readOperands(line)
 	if err != nil {
 		return err
 	}
 
-	if token.Type != TokenNumber && token.Type != TokenIdentifier {
-		return fmt.Errorf("DEFS requires size at line %d", token.Line)
+	if len(operands) < 1 || len(operands) > 2 {
+		return directiveError(p.filename, line, "DEFS requires size")
 	}
 
-	size, err := p.evaluateExpression(token.Value)
+	// The size moves every later label, so it must be known in pass 1
+	size, err := p.evaluateResolved(operands[0])
 	if err != nil {
 		return err
 	}
+	if size < 0 {
+		return valueError(p.filename, line, "negative DEFS size: %d", size)
+	}
 
 	// Check for fill value
 	fillValue := 0
-	token, err = p.nextToken()
+	if len(operands) == 2 {
+		fillValue, err = p.evaluateExpression(operands[1])
+		if err != nil {
+			return err
+		}
+		if fillValue < -128 || fillValue > 255 {
+			return valueError(p.filename, line, "invalid DEFS fill value: %d", fillValue)
+		}
+	}
+
+	// Emit the fill bytes
+	for i := 0; i < size; i++ {
+		p.assembler.emitByte(byte(fillValue))
+	}
+
+	return nil
+}
+
+// parseALIGN handles ALIGN boundary [,fill], padding up to the next
+// address that is a multiple of boundary
+func (p *Parser) parseALIGN(line int) error {
+	operands, err := p.readOperands(line)
+	if err != nil {
+		return err
+	}
+
+	if len(operands) < 1 || len(operands) > 2 {
+		return directiveError(p.filename, line, "ALIGN requires boundary")
+	}
+
+	// The padding moves every later label, so it must be known in pass 1
+	boundary, err := p.evaluateResolved(operands[0])
 	if err != nil {
 		return err
 	}
+	if boundary < 1 || boundary > memorySize {
+		return valueError(p.filename, line, "ALIGN boundary out of range (1 to %d): %d", memorySize, boundary)
+	}
 
-	if token.Type == TokenComma {
-		token, err = p.nextToken()
+	fillValue := 0
+	if len(operands) == 2 {
+		fillValue, err = p.evaluateExpression(operands[1])
 		if err != nil {
 			return err
 		}
-		if token.Type != TokenNumber && token.Type != TokenIdentifier {
-			return fmt.Errorf("invalid DEFS fill value at line %d", token.Line)
-		}
-		fillValue, err = p.evaluateExpression(token.Value)
-		if err != nil {
-			return err
+		if fillValue < -128 || fillValue > 255 {
+			return valueError(p.filename, line, "invalid ALIGN fill value: %d", fillValue)
 		}
 	}
 
-	// Emit the fill bytes
-	for i := 0; i < size; i++ {
+	// Alignment is of the address the code runs at
+	for p.assembler.logicalAddr()%boundary != 0 {
 		p.assembler.emitByte(byte(fillValue))
 	}
 
@@ -220,80 +410,133 @@ func (p *Parser) parseDEFS() error {
 }
 
 // parseINCLUDE handles the INCLUDE directive
-func (p *Parser) parseINCLUDE() error {
+func (p *Parser) parseINCLUDE(line int) error {
 	// Get filename
-	token, err := p.nextToken()
+	operands, err := p.readOperands(line)
 	if err != nil {
 		return err
 	}
 
-	if token.Type != TokenString {
-		return fmt.Errorf("INCLUDE requires filename at line %d", token.Line)
+	filename, ok := "", len(operands) == 1
+	if ok {
+		filename, ok = stringOperand(operands[0])
+	}
+	if !ok {
+		return directiveError(p.filename, line, "INCLUDE requires filename")
 	}
-
-	filename := token.Value
 
 	// Process the included file
-	if err := p.assembler.processIncludeFile(filename); err != nil {
-		return fmt.Errorf("error processing include file %s: %v", filename, err)
+	from := SourceLocation{File: p.filename, Line: line}
+	if err := p.assembler.processIncludeFile(filename, from); err != nil {
+		return fileError(p.filename, line, "%v", err)
 	}
 
 	return nil
 }
 
-// parseINCBIN handles the INCBIN directive
-func (p *Parser) parseINCBIN() error {
-	// Get filename
-	token, err := p.nextToken()
+// parseEND handles END [entry]. The rest of the file is not assembled; an
+// INCLUDE that ends this way returns to the including file. IF blocks and
+// modules the file left open end with it. The entry address is where
+// execution starts, which the output formats use.
+func (p *Parser) parseEND(line int) error {
+	if p.expansion {
+		return directiveError(p.filename, line, "END is not allowed inside a macro or repeat block")
+	}
+	p.ended = true
+	p.conditionals = nil
+	p.assembler.closeModules(p.filename)
+
+	operands, err := p.readOperands(line)
 	if err != nil {
 		return err
 	}
-
-	if token.Type != TokenString {
-		return fmt.Errorf("INCBIN requires filename at line %d", token.Line)
+	if len(operands) > 1 {
+		return directiveError(p.filename, line, "END takes at most an entry address")
+	}
+	if len(operands) == 0 || p.assembler.pass != finalPass {
+		return nil
 	}
 
-	filename := token.Value
+	addr, err := p.evaluateExpression(operands[0])
+	if err != nil {
+		return err
+	}
+	if addr < 0 || addr >= memorySize {
+		return rangeError(p.filename, line, "END entry address out of range: %d", addr)
+	}
 
-	// Check for optional skip and length parameters
-	var skip, length int = 0, -1
+	a := p.assembler
+	if a.entryFrom != nil && a.entry != addr {
+		return directiveError(p.filename, line, "entry address already set to $%04X at %s",
+			uint16(a.entry), a.entryFrom)
+	}
+	a.entry = addr
+	a.entryFrom = &SourceLocation{File: p.filename, Line: line}
+	return nil
+}
 
-	token, err = p.nextToken()
+// parseINCBIN handles the INCBIN directive
+func (p *Parser) parseINCBIN(line int) error {
+	// Get filename
+	operands, err := p.readOperands(line)
 	if err != nil {
 		return err
 	}
 
-	if token.Type == TokenComma {
-		// Parse skip value
-		token, err = p.nextToken()
+	filename, ok := "", len(operands) >= 1 && len(operands) <= 3
+	if ok {
+		filename, ok = stringOperand(operands[0])
+	}
+	if !ok {
+		return directiveError(p.filename, line, "INCBIN requires filename")
+	}
+
+	// Check for optional skip and length parameters
+	var skip, length int
+
+	if len(operands) > 1 {
+		skip, err = p.evaluateResolved(operands[1])
 		if err != nil {
 			return err
 		}
-		skip, err = p.evaluateExpression(token.Value)
+	}
+	if len(operands) > 2 {
+		length, err = p.evaluateResolved(operands[2])
 		if err != nil {
 			return err
 		}
+	}
 
-		token, err = p.nextToken()
-		if err != nil {
-			return err
-		}
+	// Find the file next to the source or along the include path
+	path, err := p.assembler.findFile(filename, SourceLocation{File: p.filename, Line: line}, "binary")
+	if err != nil {
+		return fileError(p.filename, line, "%v", err)
+	}
 
-		if token.Type == TokenComma {
-			// Parse length value
-			token, err = p.nextToken()
-			if err != nil {
-				return err
-			}
-			length, err = p.evaluateExpression(token.Value)
-			if err != nil {
-				return err
-			}
-		}
+	// Pass 1 needs the size as much as pass 2 needs the bytes
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fileError(p.filename, line, "failed to read binary file %s: %v", path, err)
+	}
+
+	// The requested range must lie inside the file
+	if skip < 0 || skip > len(data) {
+		return valueError(p.filename, line, "INCBIN skip %d outside %s (%d bytes)",
+			skip, filename, len(data))
+	}
+	// Without a length the rest of the file is included
+	if len(operands) < 3 {
+		length = len(data) - skip
+	}
+	if length < 0 {
+		return valueError(p.filename, line, "INCBIN length must not be negative: %d", length)
+	}
+	if skip+length > len(data) {
+		return valueError(p.filename, line, "INCBIN length %d after skip %d exceeds %s (%d bytes)",
+			length, skip, filename, len(data))
 	}
 
-	// Record the binary file for later processing
-	p.assembler.recordBinaryFile(filename, skip, length)
+	p.assembler.includeBinary(path, data, skip, length)
 
 	return nil
 }
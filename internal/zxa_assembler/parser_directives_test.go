@@ -0,0 +1,173 @@
+// file: internal/zxa_assembler/parser_directives_test.go
+
+package zxa_assembler
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIncludeSearch(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"src/main.asm":   " INCLUDE \"near.inc\"\n INCLUDE \"lib.inc\"\n INCBIN \"data.bin\"\n",
+		"src/near.inc":   " DEFB 1\n",
+		"lib/lib.inc":    " DEFB 2\n INCLUDE \"nested.inc\"\n",
+		"lib/nested.inc": " DEFB 3\n",
+		"lib/data.bin":   "\x04\x05",
+		"other/lib.inc":  " DEFB 99\n",
+	})
+	a := NewAssembler(AssemblerOptions{})
+	a.AddIncludePath(filepath.Join(dir, "lib"))
+	a.AddIncludePath(filepath.Join(dir, "other"))
+	result, err := a.Assemble(filepath.Join(dir, "src", "main.asm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{1, 2, 3, 4, 5}; !bytes.Equal(result.Binary, want) {
+		t.Fatalf("got % X, want % X", result.Binary, want)
+	}
+
+	paths := make(map[string]string)
+	for _, inc := range result.IncludedFiles {
+		paths[inc.Name] = filepath.ToSlash(inc.Path)
+	}
+	for name, dir := range map[string]string{"near.inc": "src", "lib.inc": "lib", "nested.inc": "lib", "data.bin": "lib"} {
+		if !strings.HasSuffix(paths[name], dir+"/"+name) {
+			t.Errorf("%s read from %q, want it from %s", name, paths[name], dir)
+		}
+	}
+}
+
+func TestIncludePathBeforeCurrentDir(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"src/main.asm":   " INCLUDE \"shared.inc\"\n INCLUDE \"only.inc\"\n",
+		"lib/shared.inc": " DEFB 2\n",
+		"cwd/shared.inc": " DEFB 1\n",
+		"cwd/only.inc":   " DEFB 3\n",
+	})
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Join(dir, "cwd")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	a := NewAssembler(AssemblerOptions{})
+	a.AddIncludePath(filepath.Join(dir, "lib"))
+	result, err := a.Assemble(filepath.Join(dir, "src", "main.asm"))
+	checkResult(t, result, err, []byte{2, 3}, "")
+}
+
+func TestIncludeNotFound(t *testing.T) {
+	_, _, err := assembleSource(t, " INCLUDE \"missing.inc\"\n")
+	if err == nil || !strings.Contains(err.Error(), "file not found: missing.inc") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestINCBIN(t *testing.T) {
+	data := map[string]string{"data.bin": "\x00\x01\x02\x03\x04\x05"}
+	tests := []asmCase{
+		{name: "whole file", src: " ORG $8000\n INCBIN \"data.bin\"\n", want: []byte{0, 1, 2, 3, 4, 5}},
+		{name: "skip", src: " INCBIN \"data.bin\", 4\n", want: []byte{4, 5}},
+		{name: "skip and length", src: " INCBIN \"data.bin\", 1, 2\n", want: []byte{1, 2}},
+		{name: "labels after", src: " ORG $8000\n INCBIN \"data.bin\", 0, 3\nafter: DEFW after\n", want: []byte{0, 1, 2, 3, 0x80}},
+		{name: "skip past end", src: " INCBIN \"data.bin\", 7\n", wantErr: "INCBIN skip 7 outside data.bin"},
+		{name: "length past end", src: " INCBIN \"data.bin\", 2, 5\n", wantErr: "INCBIN length 5 after skip 2 exceeds data.bin"},
+		{name: "negative length", src: " INCBIN \"data.bin\", 0, -1\n", wantErr: "INCBIN length must not be negative: -1"},
+		{name: "no file name", src: " INCBIN\n", wantErr: "INCBIN requires filename"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, result, err := assembleFiles(t, AssemblerOptions{}, tc.src, data)
+			checkResult(t, result, err, tc.want, tc.wantErr)
+		})
+	}
+}
+
+func TestALIGN(t *testing.T) {
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{name: "pads to boundary", src: " ORG $8001\n ALIGN 4\nhere: DW here\n", want: []byte{0, 0, 0, 0x04, 0x80}},
+		{name: "already aligned", src: " ORG $8000\n ALIGN 256\n DB 1\n", want: []byte{1}},
+		{name: "fill value", src: " ORG $8000\n DB 1\n ALIGN 4, $FF\n DB 2\n", want: []byte{1, 0xFF, 0xFF, 0xFF, 2}},
+		{name: "zero boundary", src: " ALIGN 0\n", wantErr: "ALIGN boundary out of range"},
+		{name: "bad fill", src: " DB 1\n ALIGN 2, 300\n", wantErr: "invalid ALIGN fill value: 300"},
+	})
+}
+
+func TestSymbolAssignment(t *testing.T) {
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{name: "EQU", src: "v EQU 5\n DB v\n", want: []byte{5}},
+		{name: "EQU with colon", src: "v: EQU 5\n DB v\n", want: []byte{5}},
+		{name: "EQU repeated with the same value", src: "v EQU 5\nv EQU 2+3\n DB v\n", want: []byte{5}},
+		{name: "SET", src: "v SET 1\n DB v\nv SET v+1\n DB v\n", want: []byte{1, 2}},
+		{name: "DEFL", src: "v DEFL 1\nv DEFL 7\n DB v\n", want: []byte{7}},
+		{name: "equals sign", src: "v = 3\n DB v\nv = v*2\n DB v\n", want: []byte{3, 6}},
+		{name: "EQU redefined", src: "v EQU 1\nv EQU 2\n", wantErr: "EQU v redefined from 1 to 2"},
+		{name: "SET over EQU", src: "v EQU 1\nv SET 2\n", wantErr: "v is already defined as an EQU constant"},
+		{name: "EQU over SET", src: "v SET 1\nv EQU 2\n", wantErr: "v is already defined as a SET variable"},
+		{name: "label over SET", src: "v = 1\nv: nop\n", wantErr: "v is already defined as a SET variable"},
+		{name: "EQU without label", src: " EQU 1\n", wantErr: "EQU without label"},
+	})
+}
+
+func TestEND(t *testing.T) {
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{name: "rest of the file is ignored", src: " nop\n END\n this is not assembly\n", want: []byte{0x00}},
+		{name: "entry address", src: " ORG $8000\nstart: nop\n END start\n", want: []byte{0x00}},
+		{name: "same entry twice", src: "start: nop\n END start\n", want: []byte{0x00}},
+		{name: "inside a macro", src: " MACRO m\n END\n ENDM\n m\n", wantErr: "END is not allowed inside a macro or repeat block"},
+		{name: "inside REPT", src: " REPT 2\n END\n ENDR\n", wantErr: "END is not allowed inside a macro or repeat block"},
+		{name: "too many operands", src: " END 1, 2\n", wantErr: "END takes at most an entry address"},
+		{name: "entry out of range", src: " END $10000\n", wantErr: "END entry address out of range"},
+		{
+			name: "end as a label and operand",
+			src:  " ORG $8000\nstart: ld hl, end\n DW end-start\nend: END start\n",
+			want: []byte{0x21, 0x05, 0x80, 0x05, 0x00},
+		},
+		{name: "end as a constant", src: "end EQU 2\n DB end\n", want: []byte{2}},
+		{name: "inside IF", src: " IF 1\n DB 1\n END\n ENDIF\n", want: []byte{1}},
+		{name: "inside a skipped IF", src: " IF 0\n END\n ENDIF\n DB 2\n", want: []byte{2}},
+		{name: "inside MODULE", src: " MODULE m\nx: DB 1\n END m.x\n", want: []byte{1}},
+		{name: "no entry for SAVESNA", src: " ORG $8000\n nop\n SAVESNA \"a.sna\"\n", wantErr: "SAVESNA requires an entry address, or END with one"},
+	})
+
+	files := map[string]string{"lib.inc": " DB 1\n END $8001\n DB 2\n"}
+	_, result, err := assembleFiles(t, AssemblerOptions{}, " ORG $8000\n INCLUDE \"lib.inc\"\n DB 3\n END $8001\n", files)
+	checkResult(t, result, err, []byte{1, 3}, "")
+	if result.Entry == nil || *result.Entry != 0x8001 {
+		t.Fatalf("entry %v, want $8001", result.Entry)
+	}
+
+	// END closes only the modules of its own file
+	files["mod.inc"] = " MODULE inner\n END\n"
+	a, result, err := assembleFiles(t, AssemblerOptions{}, " MODULE outer\n INCLUDE \"mod.inc\"\nx: DB 1\n ENDMODULE\n", files)
+	checkResult(t, result, err, []byte{1}, "")
+	checkSymbols(t, a, map[string]int{"outer.x": 0})
+
+	_, _, err = assembleFiles(t, AssemblerOptions{}, " ORG $8000\n INCLUDE \"lib.inc\"\n END $8000\n", files)
+	if err == nil || !strings.Contains(err.Error(), "entry address already set to $8001 at ") {
+		t.Fatalf("expected a conflicting entry error, got %v", err)
+	}
+}
+
+func TestENDReport(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.asm": " ORG $8000\nstart: nop\n SAVESNA \"a.sna\"\n END start\n"})
+	a := NewAssembler(AssemblerOptions{})
+	a.SetJSONOutput(true)
+	result, err := a.Assemble(filepath.Join(dir, "main.asm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(result.JSONReport, `"entry": 32768`) {
+		t.Errorf("report does not give the entry address:\n%s", result.JSONReport)
+	}
+	if sna := result.OutputFiles[0].Data; sna[27+0xFFFE-0x4000] != 0x00 || sna[27+0xFFFF-0x4000] != 0x80 {
+		t.Errorf("snapshot does not start at the END address")
+	}
+}
@@ -0,0 +1,344 @@
+// file: internal/zxa_assembler/parser_expressions.go
+
+package zxa_assembler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// binaryLevels lists the binary operators from lowest to highest precedence
+var binaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!=", "<>", "="},
+	{"<", "<=", ">", ">="},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// exprOperators holds every operator spelling, longest first, so that the
+// lexer always takes "<<" over "<" and "&&" over "&"
+var exprOperators = []string{
+	"||", "&&", "==", "!=", "<>", "<=", ">=", "<<", ">>",
+	"|", "^", "&", "=", "<", ">", "+", "-", "*", "/", "%", "~", "!",
+}
+
+// exprParser evaluates an operand expression by recursive descent. Values
+// are kept as 32-bit integers; range checks happen when a value is emitted.
+type exprParser struct {
+	parser    *Parser
+	input     string
+	pos       int
+	undefined string // First symbol referenced before its definition
+}
+
+// wrap32 truncates an intermediate value to a signed 32-bit integer
+func wrap32(v int64) int64 {
+	return int64(int32(v))
+}
+
+// boolValue converts a truth value to the 1/0 result of a comparison
+func boolValue(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// skipSpaces skips whitespace inside the expression
+func (e *exprParser) skipSpaces() {
+	for e.pos < len(e.input) && isSpace(rune(e.input[e.pos])) {
+		e.pos++
+	}
+}
+
+// peekOperator returns the operator at the current position, if any
+func (e *exprParser) peekOperator() string {
+	e.skipSpaces()
+	for _, op := range exprOperators {
+		if strings.HasPrefix(e.input[e.pos:], op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// parse evaluates the whole input and rejects any trailing text
+func (e *exprParser) parse() (int64, error) {
+	val, err := e.parseLevel(0)
+	if err != nil {
+		return 0, err
+	}
+	e.skipSpaces()
+	if e.pos < len(e.input) {
+		return 0, fmt.Errorf("unexpected '%s' in expression: %s", e.input[e.pos:], e.input)
+	}
+	return val, nil
+}
+
+// parseLevel parses a chain of binary operators of one precedence level
+func (e *exprParser) parseLevel(level int) (int64, error) {
+	if level == len(binaryLevels) {
+		return e.parseUnary()
+	}
+
+	left, err := e.parseLevel(level + 1)
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		op := e.peekOperator()
+		if !containsString(binaryLevels[level], op) {
+			return left, nil
+		}
+		e.pos += len(op)
+
+		right, err := e.parseLevel(level + 1)
+		if err != nil {
+			return 0, err
+		}
+		left, err = e.applyBinary(op, left, right)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// applyBinary applies a binary operator to two values
+func (e *exprParser) applyBinary(op string, left, right int64) (int64, error) {
+	switch op {
+	case "||":
+		return boolValue(left != 0 || right != 0), nil
+	case "&&":
+		return boolValue(left != 0 && right != 0), nil
+	case "|":
+		return left | right, nil
+	case "^":
+		return left ^ right, nil
+	case "&":
+		return left & right, nil
+	case "==", "=":
+		return boolValue(left == right), nil
+	case "!=", "<>":
+		return boolValue(left != right), nil
+	case "<":
+		return boolValue(left < right), nil
+	case "<=":
+		return boolValue(left <= right), nil
+	case ">":
+		return boolValue(left > right), nil
+	case ">=":
+		return boolValue(left >= right), nil
+	case "<<", ">>":
+		if right < 0 || right > 31 {
+			return 0, valueError(e.parser.filename, e.parser.statementLine,
+				"shift count out of range (0 to 31): %d", right)
+		}
+		if op == "<<" {
+			return wrap32(left << uint(right)), nil
+		}
+		return left >> uint(right), nil
+	case "+":
+		return wrap32(left + right), nil
+	case "-":
+		return wrap32(left - right), nil
+	case "*":
+		return wrap32(left * right), nil
+	case "/", "%":
+		if right == 0 {
+			// A placeholder for a forward reference may well be zero
+			if e.undefined != "" {
+				return 0, nil
+			}
+			return 0, valueError(e.parser.filename, e.parser.statementLine,
+				"division by zero in expression: %s", e.input)
+		}
+		if op == "/" {
+			return wrap32(left / right), nil
+		}
+		return left % right, nil
+	}
+	return 0, fmt.Errorf("unknown operator: %s", op)
+}
+
+// parseUnary parses unary operators, including HIGH and LOW
+func (e *exprParser) parseUnary() (int64, error) {
+	e.skipSpaces()
+
+	switch op := e.peekOperator(); op {
+	case "-", "+", "~", "!":
+		e.pos++
+		val, err := e.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case "-":
+			return wrap32(-val), nil
+		case "~":
+			return ^val, nil
+		case "!":
+			return boolValue(val == 0), nil
+		}
+		return val, nil
+	}
+
+	// HIGH and LOW select a byte of the 16-bit value that follows
+	start := e.pos
+	word := e.readWord()
+	switch strings.ToUpper(word) {
+	case "HIGH", "LOW":
+		val, err := e.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		if strings.ToUpper(word) == "HIGH" {
+			return (val >> 8) & 0xFF, nil
+		}
+		return val & 0xFF, nil
+	}
+	e.pos = start
+
+	return e.parsePrimary()
+}
+
+// readWord reads an identifier at the current position, including local
+// names such as .loop, global names such as @init and qualified names such
+// as routine.loop
+func (e *exprParser) readWord() string {
+	start := e.pos
+	if e.pos < len(e.input) && (isAlpha(rune(e.input[e.pos])) || e.isPrefixedName()) {
+		e.pos++
+		for e.pos < len(e.input) && (isAlphaNum(rune(e.input[e.pos])) || e.input[e.pos] == '.') {
+			e.pos++
+		}
+	}
+	return e.input[start:e.pos]
+}
+
+// isPrefixedName reports whether a local name such as .loop or a global
+// name such as @init starts here
+func (e *exprParser) isPrefixedName() bool {
+	return (e.input[e.pos] == '.' || e.input[e.pos] == '@') &&
+		e.pos+1 < len(e.input) && isAlpha(rune(e.input[e.pos+1]))
+}
+
+// parsePrimary parses numbers, symbols, function calls, $ and
+// parenthesised expressions
+func (e *exprParser) parsePrimary() (int64, error) {
+	e.skipSpaces()
+	if e.pos >= len(e.input) {
+		return 0, fmt.Errorf("missing value in expression: %s", e.input)
+	}
+
+	c := e.input[e.pos]
+	switch {
+	case c == '(':
+		e.pos++
+		val, err := e.parseLevel(0)
+		if err != nil {
+			return 0, err
+		}
+		e.skipSpaces()
+		if e.pos >= len(e.input) || e.input[e.pos] != ')' {
+			return 0, fmt.Errorf("missing closing parenthesis in expression: %s", e.input)
+		}
+		e.pos++
+		return val, nil
+
+	case c == '$':
+		// $ on its own is the address of the current statement
+		start := e.pos
+		e.pos++
+		for e.pos < len(e.input) && isValidHexDigit(e.input[e.pos]) {
+			e.pos++
+		}
+		if e.pos == start+1 {
+			return int64(e.parser.statementAddr), nil
+		}
+		return parseNumber(e.input[start:e.pos])
+
+	case c == '%':
+		start := e.pos
+		e.pos++
+		for e.pos < len(e.input) && isValidBinaryDigit(e.input[e.pos]) {
+			e.pos++
+		}
+		return parseNumber(e.input[start:e.pos])
+
+	case isDigit(rune(c)):
+		start := e.pos
+		for e.pos < len(e.input) && isAlphaNum(rune(e.input[e.pos])) {
+			e.pos++
+		}
+		word := e.input[start:e.pos]
+
+		// 1b and 1f refer to the nearest anonymous label 1: back or forward
+		if ref, dir := word[:len(word)-1], word[len(word)-1]; isDecimal(ref) && strings.ContainsRune("bBfF", rune(dir)) {
+			return e.anonymous(word, ref, dir == 'f' || dir == 'F')
+		}
+		return parseNumber(word)
+
+	case c == '\'':
+		length := quotedLength(e.input[e.pos:])
+		if length < 0 {
+			return 0, fmt.Errorf("unterminated character constant in expression: %s", e.input)
+		}
+		lit := e.input[e.pos : e.pos+length]
+		e.pos += length
+		// The charmap only applies to string data, so CP 'A' means what it
+		// always has
+		c, err := charLiteral(lit)
+		if err != nil {
+			return 0, err
+		}
+		return int64(c.value), nil
+
+	case isAlpha(rune(c)) || e.isPrefixedName():
+		name := e.readWord()
+		if e.isFunctionCall(name) {
+			return e.parseCall(name)
+		}
+		if sym, exists := e.parser.lookupSymbol(name); exists {
+			return int64(sym.Value), nil
+		}
+		if e.undefined == "" {
+			e.undefined = name
+		}
+		return 0, nil
+	}
+
+	return 0, fmt.Errorf("unexpected character '%c' in expression: %s", c, e.input)
+}
+
+// anonymous resolves a reference to an anonymous label. A forward label is
+// only known once pass 1 has seen it, so it is a placeholder until then.
+func (e *exprParser) anonymous(word, name string, forward bool) (int64, error) {
+	addr, ok, err := e.parser.assembler.anonymousLabel(name, forward)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		if e.undefined == "" {
+			e.undefined = word
+		}
+		return 0, nil
+	}
+	return int64(addr), nil
+}
+
+// containsString reports whether list holds s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+// file: internal/zxa_assembler/parser_expressions_test.go
+
+package zxa_assembler
+
+import "testing"
+
+func TestExpressions(t *testing.T) {
+	tests := []struct {
+		expr string
+		want int
+	}{
+		{"1+2*3", 7},
+		{"(1+2)*3", 9},
+		{"10-2-3", 5},
+		{"100/7", 14},
+		{"100%7", 2},
+		{"-5+10", 5},
+		{"~0 & $FF", 0xFF},
+		{"!0", 1},
+		{"!7", 0},
+		{"1<<4 | 1", 17},
+		{"$F0 >> 4", 0x0F},
+		{"$F0 ^ $FF", 0x0F},
+		{"3 == 3", 1},
+		{"3 <> 3", 0},
+		{"2 < 3 && 3 < 4", 1},
+		{"0 || 0", 0},
+		{"HIGH $1234", 0x12},
+		{"LOW $1234", 0x34},
+		{"high($ABCD) + 1", 0xAC},
+		{"0FFh", 0xFF},
+		{"%1010", 10},
+		{"0x10", 16},
+		{"$8000 + 2*3", 0x8006},
+	}
+	for _, tc := range tests {
+		t.Run(tc.expr, func(t *testing.T) {
+			a, _, err := assembleSource(t, "value EQU "+tc.expr+"\n")
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSymbols(t, a, map[string]int{"value": tc.want})
+		})
+	}
+}
+
+func TestCurrentAddress(t *testing.T) {
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{
+			name: "dollar in data",
+			src:  " ORG $8000\n DEFW $, $+2\n",
+			want: []byte{0x00, 0x80, 0x02, 0x80},
+		},
+		{
+			name: "relative jump to self",
+			src:  " ORG $8000\n jr $\n",
+			want: []byte{0x18, 0xFE},
+		},
+		{
+			name: "length of data",
+			src:  " ORG $8000\nmsg: DEFB 1,2,3\nlen EQU $-msg\n DEFB len\n",
+			want: []byte{1, 2, 3, 3},
+		},
+	})
+}
+
+func TestExpressionErrors(t *testing.T) {
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{name: "division by zero", src: " DEFB 1/0\n", wantErr: "division by zero"},
+		{name: "missing parenthesis", src: "x EQU (1+2\n", wantErr: "parenthesis"},
+		{name: "shift out of range", src: " DEFW 1<<40\n", wantErr: "shift count out of range"},
+		{name: "trailing text", src: " DEFB 1 2\n", wantErr: "unexpected"},
+		{name: "byte out of range", src: " DEFB 256\n", wantErr: "out of range"},
+	})
+}
@@ -0,0 +1,248 @@
+// file: internal/zxa_assembler/parser_functions.go
+
+package zxa_assembler
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// exprFunction is a built-in function of expressions. Every function works
+// on integers and rounds the same way on every platform, so generated
+// tables never depend on the machine the source is assembled on.
+type exprFunction struct {
+	usage    string
+	min, max int // Number of arguments
+	apply    func(e *exprParser, args []int64) (int64, error)
+}
+
+// Defaults of the trigonometric functions: a turn of 256 steps, so that a
+// byte indexes a whole circle, and results in 8.8 fixed point
+const (
+	defaultTurn      = 256
+	defaultTrigScale = 256
+)
+
+// exprFunctions holds the built-in functions by upper case name:
+//
+//	SIN(angle [,scale [,turn]])  sine of angle/turn of a circle, times scale
+//	COS(angle [,scale [,turn]])  cosine, likewise
+//	SQRT(x)                      square root, rounded down
+//	ABS(x)                       absolute value
+//	MIN(a, b, ...)               smallest argument
+//	MAX(a, b, ...)               largest argument
+//	CLAMP(x, lo, hi)             x limited to lo..hi
+//	SCALE(x, num, den)           x*num/den, rounded
+//	FIXMUL(a, b, bits)           product of two fixed-point values
+//	FIXDIV(a, b, bits)           quotient as a fixed-point value
+//
+// Rounding is to the nearest integer, halves away from zero. The bits of
+// the fixed-point helpers are the fractional bits, so FIXDIV(1, n, 16) is
+// 65536/n, the entry of a reciprocal table.
+var exprFunctions = map[string]exprFunction{
+	"SIN": {"SIN(angle [,scale [,turn]])", 1, 3, func(e *exprParser, args []int64) (int64, error) {
+		return e.trig(args, 0)
+	}},
+	"COS": {"COS(angle [,scale [,turn]])", 1, 3, func(e *exprParser, args []int64) (int64, error) {
+		return e.trig(args, 1)
+	}},
+	"SQRT": {"SQRT(x)", 1, 1, func(e *exprParser, args []int64) (int64, error) {
+		if args[0] < 0 {
+			return 0, e.domainError("square root of a negative number: %d", args[0])
+		}
+		return isqrt(args[0]), nil
+	}},
+	"ABS": {"ABS(x)", 1, 1, func(e *exprParser, args []int64) (int64, error) {
+		if args[0] < 0 {
+			return wrap32(-args[0]), nil
+		}
+		return args[0], nil
+	}},
+	"MIN": {"MIN(a, b, ...)", 2, -1, func(e *exprParser, args []int64) (int64, error) {
+		least := args[0]
+		for _, v := range args[1:] {
+			least = min(least, v)
+		}
+		return least, nil
+	}},
+	"MAX": {"MAX(a, b, ...)", 2, -1, func(e *exprParser, args []int64) (int64, error) {
+		greatest := args[0]
+		for _, v := range args[1:] {
+			greatest = max(greatest, v)
+		}
+		return greatest, nil
+	}},
+	"CLAMP": {"CLAMP(x, lo, hi)", 3, 3, func(e *exprParser, args []int64) (int64, error) {
+		if args[1] > args[2] {
+			return 0, e.domainError("CLAMP range is empty: %d to %d", args[1], args[2])
+		}
+		return min(max(args[0], args[1]), args[2]), nil
+	}},
+	"SCALE": {"SCALE(x, num, den)", 3, 3, func(e *exprParser, args []int64) (int64, error) {
+		return e.divideRounded(args[0]*args[1], args[2])
+	}},
+	"FIXMUL": {"FIXMUL(a, b, bits)", 3, 3, func(e *exprParser, args []int64) (int64, error) {
+		if err := e.checkFractionBits(args[2]); err != nil {
+			return 0, err
+		}
+		return e.divideRounded(args[0]*args[1], 1<<args[2])
+	}},
+	"FIXDIV": {"FIXDIV(a, b, bits)", 3, 3, func(e *exprParser, args []int64) (int64, error) {
+		if err := e.checkFractionBits(args[2]); err != nil {
+			return 0, err
+		}
+		return e.divideRounded(args[0]<<args[2], args[1])
+	}},
+}
+
+// isFunctionCall reports whether name followed by an opening parenthesis,
+// which makes it a function call rather than a symbol
+func (e *exprParser) isFunctionCall(name string) bool {
+	if _, ok := exprFunctions[strings.ToUpper(name)]; !ok {
+		return false
+	}
+	e.skipSpaces()
+	return e.pos < len(e.input) && e.input[e.pos] == '('
+}
+
+// parseCall evaluates the parenthesised arguments of a function and
+// applies it. The opening parenthesis is at the current position.
+func (e *exprParser) parseCall(name string) (int64, error) {
+	fn := exprFunctions[strings.ToUpper(name)]
+	e.pos++
+
+	var args []int64
+	for {
+		val, err := e.parseLevel(0)
+		if err != nil {
+			return 0, err
+		}
+		args = append(args, val)
+
+		e.skipSpaces()
+		if e.pos < len(e.input) && e.input[e.pos] == ',' {
+			e.pos++
+			continue
+		}
+		if e.pos >= len(e.input) || e.input[e.pos] != ')' {
+			return 0, fmt.Errorf("missing closing parenthesis in expression: %s", e.input)
+		}
+		e.pos++
+		break
+	}
+
+	if len(args) < fn.min || fn.max >= 0 && len(args) > fn.max {
+		return 0, valueError(e.parser.filename, e.parser.statementLine, "%s requires %s", strings.ToUpper(name), fn.usage)
+	}
+	val, err := fn.apply(e, args)
+	if err != nil {
+		return 0, err
+	}
+	return wrap32(val), nil
+}
+
+// domainError reports an argument a function cannot take. A placeholder
+// for a forward reference may well be such an argument, so it is let
+// through until the symbol is known.
+func (e *exprParser) domainError(format string, args ...interface{}) error {
+	if e.undefined != "" {
+		return nil
+	}
+	return valueError(e.parser.filename, e.parser.statementLine, format, args...)
+}
+
+// checkFractionBits checks the number of fractional bits of a fixed-point
+// helper
+func (e *exprParser) checkFractionBits(bits int64) error {
+	if bits < 0 || bits > 24 {
+		return valueError(e.parser.filename, e.parser.statementLine,
+			"fixed-point fraction bits out of range (0 to 24): %d", bits)
+	}
+	return nil
+}
+
+// divideRounded divides to the nearest integer, halves away from zero
+func (e *exprParser) divideRounded(num, den int64) (int64, error) {
+	if den == 0 {
+		return 0, e.domainError("division by zero in expression: %s", e.input)
+	}
+	if den < 0 {
+		num, den = -num, -den
+	}
+	if num < 0 {
+		return -((-num + den/2) / den), nil
+	}
+	return (num + den/2) / den, nil
+}
+
+// trig evaluates SIN (phase 0) or COS (phase 1, a quarter turn ahead).
+// The angle is reduced to a quarter turn in integers, so the axes are
+// exact; the rest is a series with every step rounded to float64, which
+// the compiler may not fuse, so the result is the same everywhere.
+func (e *exprParser) trig(args []int64, phase int64) (int64, error) {
+	scale, turn := int64(defaultTrigScale), int64(defaultTurn)
+	if len(args) > 1 {
+		scale = args[1]
+	}
+	if len(args) > 2 {
+		turn = args[2]
+	}
+	if turn <= 0 {
+		if e.undefined != "" {
+			return 0, nil
+		}
+		return 0, valueError(e.parser.filename, e.parser.statementLine, "turn must be positive: %d", turn)
+	}
+
+	// Quarter turns from the angle: the quadrant and the step within it
+	steps := (args[0]%turn + turn) % turn * 4
+	quadrant := (steps/turn + phase) % 4
+	x := float64(math.Pi/2) * (float64(steps%turn) / float64(turn))
+
+	var v float64
+	switch quadrant {
+	case 0:
+		v = sinSeries(x)
+	case 1:
+		v = cosSeries(x)
+	case 2:
+		v = -sinSeries(x)
+	default:
+		v = -cosSeries(x)
+	}
+	return int64(math.Round(float64(v * float64(scale)))), nil
+}
+
+// sinSeries returns the sine of x in 0..pi/2 from its Taylor series
+func sinSeries(x float64) float64 {
+	sum, term := x, x
+	for n := 1; n < 12; n++ {
+		term = float64(-term * x * x / float64((2*n)*(2*n+1)))
+		sum = float64(sum + term)
+	}
+	return sum
+}
+
+// cosSeries returns the cosine of x in 0..pi/2 from its Taylor series
+func cosSeries(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for n := 1; n < 12; n++ {
+		term = float64(-term * x * x / float64((2*n-1)*(2*n)))
+		sum = float64(sum + term)
+	}
+	return sum
+}
+
+// isqrt returns the square root of x rounded down
+func isqrt(x int64) int64 {
+	r := int64(math.Sqrt(float64(x)))
+	// Correct the estimate, which a float64 may put one out either way
+	for r*r > x {
+		r--
+	}
+	for (r+1)*(r+1) <= x {
+		r++
+	}
+	return r
+}
@@ -0,0 +1,83 @@
+// file: internal/zxa_assembler/parser_functions_test.go
+
+package zxa_assembler
+
+import "testing"
+
+func TestFunctions(t *testing.T) {
+	tests := []struct {
+		expr string
+		want int
+	}{
+		{"SIN(0)", 0},
+		{"SIN(64)", 256},
+		{"SIN(128)", 0},
+		{"SIN(192)", -256},
+		{"COS(0)", 256},
+		{"cos(128, 100)", -100},
+		{"SIN(1, 1000, 4)", 1000},
+		{"SIN(-64)", -256},
+		{"SIN(32, 127)", 90},
+		{"SQRT(65535)", 255},
+		{"SQRT(65536)", 256},
+		{"SQRT(0)", 0},
+		{"ABS(-7)", 7},
+		{"MIN(3, -1, 2)", -1},
+		{"MAX(3, -1, 2)", 3},
+		{"CLAMP(300, 0, 255)", 255},
+		{"CLAMP(-5, 0, 255)", 0},
+		{"SCALE(10, 2, 3)", 7},
+		{"SCALE(-3, 1, 2)", -2},
+		{"FIXMUL($180, $200, 8)", 0x300},
+		{"FIXDIV(1, 3, 16)", 0x5555},
+		{"FIXDIV(1, 2, 8)", 0x80},
+		{"2 * SQRT(16) + 1", 9},
+	}
+	for _, tc := range tests {
+		t.Run(tc.expr, func(t *testing.T) {
+			a, _, err := assembleSource(t, "value EQU "+tc.expr+"\n")
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSymbols(t, a, map[string]int{"value": tc.want})
+		})
+	}
+}
+
+func TestFunctionTables(t *testing.T) {
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{
+			name: "sine table",
+			src:  "k = 0\n REPT 5\n DB SIN(k*16, 127)\nk = k + 1\n ENDR\n",
+			want: []byte{0x00, 0x31, 0x5A, 0x75, 0x7F},
+		},
+		{
+			name: "reciprocal table",
+			src:  "n = 1\n REPT 4\n DW FIXDIV(1, n, 12)\nn = n + 1\n ENDR\n",
+			want: []byte{0x00, 0x10, 0x00, 0x08, 0x55, 0x05, 0x00, 0x04},
+		},
+		{
+			name: "forward reference as an argument",
+			src:  " DB SQRT(size)\nsize EQU 49\n",
+			want: []byte{7},
+		},
+		{
+			name: "a symbol named like a function",
+			src:  "min EQU 3\n DB min\n",
+			want: []byte{3},
+		},
+	})
+}
+
+func TestFunctionErrors(t *testing.T) {
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{name: "negative square root", src: " DB SQRT(-1)\n", wantErr: "square root of a negative number: -1"},
+		{name: "division by zero", src: " DB SCALE(1, 2, 0)\n", wantErr: "division by zero"},
+		{name: "too few arguments", src: " DB MIN(1)\n", wantErr: "MIN requires MIN(a, b, ...)"},
+		{name: "too many arguments", src: " DB SQRT(1, 2)\n", wantErr: "SQRT requires SQRT(x)"},
+		{name: "empty clamp", src: " DB CLAMP(1, 5, 2)\n", wantErr: "CLAMP range is empty: 5 to 2"},
+		{name: "fraction bits", src: " DW FIXMUL(1, 1, 25)\n", wantErr: "fixed-point fraction bits out of range (0 to 24): 25"},
+		{name: "bad turn", src: " DB SIN(1, 1, 0)\n", wantErr: "turn must be positive: 0"},
+		{name: "unclosed call", src: " DB SIN(1\n", wantErr: "missing closing parenthesis"},
+	})
+}
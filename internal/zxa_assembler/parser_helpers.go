@@ -3,8 +3,11 @@
 package zxa_assembler
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 // isSpace returns true if the character is whitespace
@@ -27,6 +30,43 @@ func isAlphaNum(c rune) bool {
 	return isAlpha(c) || isDigit(c)
 }
 
+// isSymbolName reports whether s is a valid symbol name
+func isSymbolName(s string) bool {
+	for i, c := range s {
+		if !isAlpha(c) && (i == 0 || !isDigit(c)) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// symbolKind describes a symbol type in messages
+func symbolKind(kind string) string {
+	switch kind {
+	case "equ":
+		return "an EQU constant"
+	case "set":
+		return "a SET variable"
+	case "struct":
+		return "a struct"
+	case "field":
+		return "a struct field"
+	default:
+		return "a " + kind
+	}
+}
+
+// isDecimal reports whether s is a run of decimal digits, such as the
+// name of an anonymous label
+func isDecimal(s string) bool {
+	for _, c := range s {
+		if !isDigit(c) {
+			return false
+		}
+	}
+	return s != ""
+}
+
 // isRegister checks if a string represents a Z80 register name
 func isRegister(s string) bool {
 	registers := map[string]bool{
@@ -34,6 +74,8 @@ func isRegister(s string) bool {
 		"E": true, "H": true, "L": true, "I": true,
 		"R": true, "BC": true, "DE": true, "HL": true,
 		"SP": true, "IX": true, "IY": true, "AF": true,
+		"AF'": true, "IXH": true, "IXL": true, "IYH": true,
+		"IYL": true,
 	}
 	return registers[strings.ToUpper(s)]
 }
@@ -43,8 +85,159 @@ func isDirective(s string) bool {
 	directives := map[string]bool{
 		"ORG": true, "EQU": true, "DEFB": true,
 		"DEFW": true, "DEFS": true, "INCLUDE": true,
-		"INCBIN": true,
+		"INCBIN": true, "IF": true, "IFDEF": true,
+		"IFNDEF": true, "ELSEIF": true, "ELSE": true,
+		"ENDIF": true, "MACRO": true, "ENDM": true,
+		"LOCAL": true, "REPT": true, "DUP": true,
+		"WHILE": true, "ENDR": true, "EDUP": true,
+		"ENDW": true, "MODULE": true, "ENDMODULE": true,
+		"STRUCT": true, "ENDS": true, "DB": true,
+		"DW": true, "DS": true, "DEFM": true,
+		"DZ": true, "DC": true, "DD": true,
+		"ALIGN": true, "ASSERT": true, "DISPLAY": true,
+		"WARNING": true, "ERROR": true, "PHASE": true,
+		"DEPHASE": true, "DEFL": true, "CHARMAP": true,
+		"SAVEBIN": true, "SAVETAP": true, "SAVESNA": true,
+		"SAVENEX": true,
 	}
 	return directives[strings.ToUpper(s)]
 }
 
+// isStatementDirective checks if a string is a directive only at the start
+// of a statement. END and the DISP and ENT aliases are common labels, so
+// anywhere else they are read as identifiers.
+func isStatementDirective(s string) bool {
+	switch strings.ToUpper(s) {
+	case "END", "DISP", "ENT":
+		return true
+	}
+	return false
+}
+
+// isIndirect reports whether an operand is wholly enclosed in parentheses,
+// as in (HL) or (nn), rather than merely starting with a bracketed term
+func isIndirect(op string) bool {
+	if !strings.HasPrefix(op, "(") || !strings.HasSuffix(op, ")") {
+		return false
+	}
+	depth := 0
+	for i, c := range op {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i == len(op)-1
+			}
+		}
+	}
+	return false
+}
+
+// stringOperand returns the contents of an operand that is a quoted string
+func stringOperand(op string) (string, bool) {
+	if len(op) < 2 || op[0] != '"' || op[len(op)-1] != '"' {
+		return "", false
+	}
+	return op[1 : len(op)-1], true
+}
+
+// escapes maps the character after a backslash to the character it stands for
+var escapes = map[byte]rune{
+	'n': '\n', 'r': '\r', 't': '\t', '0': 0, 'a': '\a', 'b': '\b',
+	'e': 0x1B, 'f': '\f', 'v': '\v', '\\': '\\', '"': '"', '\'': '\'',
+}
+
+// textChar is one character of a string or character constant. A raw
+// character, written \xHH, is a byte value that no charmap translates.
+type textChar struct {
+	value rune
+	raw   bool
+}
+
+// decodeChar decodes the character at the start of s, which may be an
+// escape sequence such as \n or \x7F, and returns it with its length
+func decodeChar(s string) (textChar, int, error) {
+	if s[0] != '\\' {
+		r, size := utf8.DecodeRuneInString(s)
+		if r == utf8.RuneError && size == 1 {
+			return textChar{}, 0, fmt.Errorf("invalid UTF-8 byte $%02X", s[0])
+		}
+		return textChar{value: r}, size, nil
+	}
+	if len(s) < 2 {
+		return textChar{}, 0, fmt.Errorf("incomplete escape sequence")
+	}
+	if s[1] == 'x' || s[1] == 'X' {
+		end := 2
+		for end < len(s) && end < 4 && isValidHexDigit(s[end]) {
+			end++
+		}
+		if end == 2 {
+			return textChar{}, 0, fmt.Errorf("\\x requires hex digits")
+		}
+		n, _ := strconv.ParseUint(s[2:end], 16, 8)
+		return textChar{value: rune(n), raw: true}, end, nil
+	}
+	if r, ok := escapes[s[1]]; ok {
+		return textChar{value: r}, 2, nil
+	}
+	return textChar{}, 0, fmt.Errorf("unknown escape sequence: \\%c", s[1])
+}
+
+// decodeString decodes the UTF-8 characters and escape sequences in the
+// contents of a string
+func decodeString(s string) ([]textChar, error) {
+	var chars []textChar
+	for i := 0; i < len(s); {
+		c, size, err := decodeChar(s[i:])
+		if err != nil {
+			return nil, err
+		}
+		chars = append(chars, c)
+		i += size
+	}
+	return chars, nil
+}
+
+// charLiteral decodes a character constant such as 'A' or '\n', written
+// with its quotes
+func charLiteral(lit string) (textChar, error) {
+	if len(lit) < 3 || lit[0] != '\'' || lit[len(lit)-1] != '\'' {
+		return textChar{}, fmt.Errorf("invalid character constant: %s", lit)
+	}
+	chars, err := decodeString(lit[1 : len(lit)-1])
+	if err != nil {
+		return textChar{}, err
+	}
+	if len(chars) != 1 {
+		return textChar{}, fmt.Errorf("character constant must hold one character: %s", lit)
+	}
+	return chars[0], nil
+}
+
+// plainText returns decoded characters as text, for messages
+func plainText(chars []textChar) string {
+	var sb strings.Builder
+	for _, c := range chars {
+		sb.WriteRune(c.value)
+	}
+	return sb.String()
+}
+
+// quotedLength returns the length of the quoted string or character
+// constant at the start of s, including both quotes, or -1 if it is not
+// closed on the same line
+func quotedLength(s string) int {
+	quote := s[0]
+	for i := 1; i < len(s) && s[i] != '\n'; i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case quote:
+			return i + 1
+		}
+	}
+	return -1
+}
@@ -0,0 +1,65 @@
+// file: internal/zxa_assembler/parser_helpers_test.go
+
+package zxa_assembler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeString(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []textChar
+		wantErr string
+	}{
+		{in: "ab", want: []textChar{{'a', false}, {'b', false}}},
+		{in: `\n\t\0`, want: []textChar{{'\n', false}, {'\t', false}, {0, false}}},
+		{in: `\"\\`, want: []textChar{{'"', false}, {'\\', false}}},
+		{in: `\x7F\x1`, want: []textChar{{0x7F, true}, {0x01, true}}},
+		{in: "é", want: []textChar{{'é', false}}},
+		{in: `\q`, wantErr: "unknown escape sequence"},
+		{in: `\x`, wantErr: "\\x requires hex digits"},
+		{in: `\`, wantErr: "incomplete escape sequence"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			got, err := decodeString(tc.in)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("got %v, want %v", got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestStringData(t *testing.T) {
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{name: "DB string", src: " DB \"Hi\\n\", 0\n", want: []byte{'H', 'i', '\n', 0}},
+		{name: "DEFM", src: " DEFM \"a\\\"b\"\n", want: []byte{'a', '"', 'b'}},
+		{name: "DZ", src: " DZ \"ab\", \"c\"\n", want: []byte{'a', 'b', 'c', 0}},
+		{name: "DC", src: " DC \"ab\"\n", want: []byte{'a', 'b' | 0x80}},
+		{name: "DD", src: " DD $12345678, -1\n", want: []byte{0x78, 0x56, 0x34, 0x12, 0xFF, 0xFF, 0xFF, 0xFF}},
+		{name: "character constants", src: " ld a, 'A'\n cp '\\n'\n DB 'x'+1\n", want: []byte{0x3E, 'A', 0xFE, '\n', 'y'}},
+		{name: "escaped quote constant", src: " DB '\\''\n", want: []byte{'\''}},
+		{name: "raw byte", src: " DB \"\\xFF\"\n", want: []byte{0xFF}},
+		{name: "non-ASCII character", src: " DB \"é\"\n", wantErr: "is not in charmap ascii"},
+		{name: "unknown escape", src: " DB \"\\q\"\n", wantErr: "unknown escape sequence"},
+		{name: "long character constant", src: " DB 'ab'\n", wantErr: "character constant must hold one character"},
+		{name: "DZ numbers", src: " DZ \"a\", 1\n", want: []byte{'a', 1, 0}},
+		{name: "DD out of range", src: " DD $100000000\n", wantErr: "invalid hexadecimal number"},
+	})
+}
@@ -4,158 +4,191 @@ package zxa_assembler
 
 import (
 	"fmt"
-	"strconv"
 	"strings"
 )
 
 // parseInstruction handles the parsing of Z80 instructions and their operands
 func (p *Parser) parseInstruction(token Token) error {
 	mnemonic := strings.ToUpper(token.Value)
-	operands := []string{}
 
 	// Read operands until end of line
-	for {
-		tok, err := p.nextToken()
-		if err != nil {
-			return err
-		}
-
-		if tok.Type == TokenNone || tok.Type == TokenDirective {
-			break
-		}
-
-		// Handle different operand types
-		switch tok.Type {
-		case TokenRegister:
-			operands = append(operands, tok.Value)
-
-		case TokenNumber, TokenIdentifier:
-			operands = append(operands, tok.Value)
-
-		case TokenLParen:
-			// Handle indirect addressing
-			indirectOp, err := p.parseIndirectOperand()
-			if err != nil {
-				return err
-			}
-			operands = append(operands, fmt.Sprintf("(%s)", indirectOp))
-
-		case TokenComma:
-			continue
-
-		default:
-			return fmt.Errorf("unexpected token in instruction at line %d: %s",
-				tok.Line, tok.Value)
-		}
-
-		// Check for comma between operands
-		next, err := p.nextToken()
-		if err != nil {
-			return err
-		}
-		if next.Type != TokenComma && next.Type != TokenNone && next.Type != TokenDirective {
-			return fmt.Errorf("expected comma between operands at line %d",
-				next.Line)
-		}
+	operands, err := p.readOperands(token.Line)
+	if err != nil {
+		return err
 	}
 
-	// Build instruction format for lookup
-	fullInst := buildInstructionString(mnemonic, operands)
-
 	// Look up the instruction
-	inst, exists := p.assembler.instructions[fullInst]
+	inst, patterns, exists := p.lookupInstruction(p.assembler.instructions, mnemonic, operands)
 	if !exists {
-		// Try generic format for immediate/extended instructions
-		genericInst := buildGenericInstruction(mnemonic, operands)
-		inst, exists = p.assembler.instructions[genericInst]
-		if !exists {
-			return fmt.Errorf("unknown instruction at line %d: %s",
-				token.Line, fullInst)
+		if err := p.checkOpcodeValue(mnemonic, operands, token.Line); err != nil {
+			return err
+		}
+		if err := checkIndexHalves(operands); err != nil {
+			return syntaxError(p.filename, token.Line, token.Column, "invalid instruction: %v", err)
 		}
+		if _, _, undocumented := p.lookupInstruction(p.assembler.undocumented, mnemonic, operands); undocumented {
+			return syntaxError(p.filename, token.Line, token.Column,
+				"undocumented instruction: %s (enable undocumented instructions to use it)",
+				buildInstructionString(mnemonic, operands))
+		}
+		return syntaxError(p.filename, token.Line, token.Column, "unknown instruction: %s",
+			buildInstructionString(mnemonic, operands))
 	}
 
 	// Generate the instruction code
-	if err := p.generateInstructionCode(inst, operands); err != nil {
+	if err := p.generateInstructionCode(inst, operands, patterns); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// buildGenericInstruction creates a generic instruction format for lookup
-func buildGenericInstruction(mnemonic string, operands []string) string {
-	// Replace numeric values with format placeholders
-	genericOps := make([]string, len(operands))
+// lookupInstruction finds the instruction table entry for the operands and
+// returns the pattern each operand matched. Operands are classified by their
+// syntax, so an instruction gets the same size in both passes even when it
+// refers to a symbol defined further down. The exception is a value that is
+// part of the opcode, which is looked up by value; see opcodeValue.
+func (p *Parser) lookupInstruction(table InstructionMap, mnemonic string, operands []string) (Instruction, []string, bool) {
+	candidates := make([][]string, len(operands))
 	for i, op := range operands {
-		if isNumeric(op) {
-			// Use 'n' for 8-bit immediates, 'nn' for 16-bit values
-			if isEightBitValue(op) {
-				genericOps[i] = "n"
-			} else {
-				genericOps[i] = "nn"
-			}
-		} else {
-			genericOps[i] = op
+		candidates[i] = operandPatterns(op)
+		if key, ok := p.opcodeValue(op); ok {
+			candidates[i] = append([]string{key}, candidates[i]...)
 		}
 	}
 
-	if len(genericOps) == 0 {
-		return mnemonic
+	patterns := make([]string, len(operands))
+	var match func(i int) (Instruction, bool)
+	match = func(i int) (Instruction, bool) {
+		if i == len(operands) {
+			inst, ok := table[buildInstructionString(mnemonic, patterns)]
+			return inst, ok
+		}
+		for _, pattern := range candidates[i] {
+			patterns[i] = pattern
+			if inst, ok := match(i + 1); ok {
+				return inst, true
+			}
+		}
+		return Instruction{}, false
 	}
-	return fmt.Sprintf("%s %s", mnemonic, strings.Join(genericOps, ","))
+
+	inst, ok := match(0)
+	return inst, patterns, ok
 }
 
-// isNumeric checks if a string represents a numeric value
-func isNumeric(s string) bool {
-	// Remove common prefixes
-	s = strings.TrimPrefix(s, "$")
-	s = strings.TrimPrefix(s, "0x")
-	s = strings.TrimPrefix(s, "%")
-	s = strings.TrimPrefix(s, "0b")
-
-	// Try parsing as different number formats
-	_, err := strconv.ParseInt(s, 0, 32)
-	return err == nil
+// opcodeValue returns the table key for an operand that may be part of the
+// opcode, such as the bit number of BIT FLAG,A or the address of RST $38.
+// In pass 1 a symbol not defined yet stands for 0, which has an entry of the
+// same size as any other value; the final pass finds the real entry, and
+// checkOpcodeValue reports a value that has none.
+func (p *Parser) opcodeValue(op string) (string, bool) {
+	if isIndirect(op) || isRegister(op) {
+		return "", false
+	}
+	val, undefined, err := p.evaluate(op)
+	if err != nil {
+		return "", false
+	}
+	if undefined != "" {
+		if p.assembler.pass == finalPass {
+			return "", false
+		}
+		val = 0
+	}
+	return fmt.Sprintf("%d", val), true
 }
 
-// isEightBitValue checks if a numeric value fits in 8 bits
-func isEightBitValue(s string) bool {
-	val, err := strconv.ParseInt(s, 0, 32)
+// checkOpcodeValue explains why BIT, RES, SET, IM or RST has no entry for
+// its operands when the value that is part of the opcode is out of range or
+// undefined
+func (p *Parser) checkOpcodeValue(mnemonic string, operands []string, line int) error {
+	if len(operands) == 0 || isIndirect(operands[0]) || isRegister(operands[0]) {
+		return nil
+	}
+	var valid bool
+	var msg string
+	val, err := p.evaluateExpression(operands[0])
 	if err != nil {
-		return false
+		return err
+	}
+	switch mnemonic {
+	case "BIT", "RES", "SET":
+		valid, msg = val >= 0 && val <= 7, ErrInvalidBitNumber
+	case "IM":
+		valid, msg = val >= 0 && val <= 2, "interrupt mode must be 0, 1 or 2, got: %d"
+	case "RST":
+		valid, msg = val >= 0 && val <= 0x38 && val%8 == 0, "restart address must be a multiple of 8 from 0 to $38, got: %d"
+	default:
+		return nil
 	}
-	return val >= -128 && val <= 255
+	if !valid {
+		return valueError(p.filename, line, msg, val)
+	}
+	return nil
 }
 
-// parseIndirectOperand handles (HL), (IX+d), etc.
-func (p *Parser) parseIndirectOperand() (string, error) {
-	var result strings.Builder
+// operandPatterns lists the instruction table spellings an operand can
+// match, most specific first
+func operandPatterns(op string) []string {
+	upper := strings.ToUpper(op)
+	if isRegister(upper) {
+		return []string{upper}
+	}
 
-	// Read tokens until closing parenthesis
-	for {
-		tok, err := p.nextToken()
-		if err != nil {
-			return "", err
+	if isIndirect(upper) {
+		inner := upper[1 : len(upper)-1]
+		switch {
+		case inner == "IX", inner == "IY":
+			// (IX) is shorthand for (IX+0)
+			return []string{upper, "(" + inner + "+d)"}
+		case isRegister(inner):
+			return []string{upper}
+		case strings.HasPrefix(inner, "IX+"), strings.HasPrefix(inner, "IX-"):
+			return []string{"(IX+d)"}
+		case strings.HasPrefix(inner, "IY+"), strings.HasPrefix(inner, "IY-"):
+			return []string{"(IY+d)"}
 		}
+		return []string{"(nn)", "(n)"}
+	}
 
-		switch tok.Type {
-		case TokenRParen:
-			return result.String(), nil
-
-		case TokenRegister, TokenIdentifier:
-			result.WriteString(tok.Value)
+	// Condition codes and fixed operands such as IM 1 match literally
+	return []string{upper, "n", "nn", "e"}
+}
 
-		case TokenPlus, TokenMinus:
-			result.WriteString(tok.Value)
+// valueOperand returns the expression of the operand that matched a value
+// placeholder, without any surrounding parentheses
+func valueOperand(operands, patterns []string) (string, bool) {
+	values := valueOperands(operands, patterns)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
 
-		case TokenNumber:
-			result.WriteString(tok.Value)
+// valueOperands returns the expressions of all operands that matched value
+// placeholders, in source order
+func valueOperands(operands, patterns []string) []string {
+	values := []string{}
+	for i, pattern := range patterns {
+		switch pattern {
+		case "n", "nn", "e":
+			values = append(values, operands[i])
+		case "(n)", "(nn)":
+			values = append(values, operands[i][1:len(operands[i])-1])
+		}
+	}
+	return values
+}
 
-		default:
-			return "", fmt.Errorf("unexpected token in indirect addressing at line %d: %s",
-				tok.Line, tok.Value)
+// indexedOperand returns the (IX+d) or (IY+d) operand of an instruction
+func indexedOperand(operands, patterns []string) (string, bool) {
+	for i, pattern := range patterns {
+		if pattern == "(IX+d)" || pattern == "(IY+d)" {
+			return operands[i], true
 		}
 	}
+	return "", false
 }
 
 // buildInstructionString creates the instruction lookup key
@@ -166,26 +199,36 @@ func buildInstructionString(mnemonic string, operands []string) string {
 	return fmt.Sprintf("%s %s", mnemonic, strings.Join(operands, ","))
 }
 
-// generateInstructionCode outputs the binary for an instruction
-func (p *Parser) generateInstructionCode(inst Instruction, operands []string) error {
+// generateInstructionCode outputs the binary for an instruction. An
+// instruction whose operand fails still takes its full length, so the code
+// after it stays where pass 1 put it.
+func (p *Parser) generateInstructionCode(inst Instruction, operands, patterns []string) (err error) {
+	start := p.assembler.logicalAddr()
+	defer func() {
+		for err != nil && p.assembler.logicalAddr() < start+inst.Length {
+			p.assembler.emitByte(0)
+		}
+	}()
+
 	// Special handling for indexed bit instructions (DDCB/FDCB prefixed)
 	if inst.Mode == IndexedBit {
 		// First byte: DD or FD prefix
 		p.assembler.emitByte(inst.Prefix)
-		
+
 		// Second byte: CB prefix
 		p.assembler.emitByte(0xCB)
-		
+
 		// Third byte: displacement
-		disp, err := p.extractDisplacement(operands[1]) // operands[1] contains (IX+d) or (IY+d)
+		op, _ := indexedOperand(operands, patterns)
+		disp, err := p.extractDisplacement(op)
 		if err != nil {
 			return err
 		}
 		p.assembler.emitByte(byte(disp))
-		
+
 		// Fourth byte: bit operation
 		p.assembler.emitByte(inst.Opcode)
-		
+
 		return nil
 	}
 
@@ -199,66 +242,109 @@ func (p *Parser) generateInstructionCode(inst Instruction, operands []string) er
 	// Handle operands based on addressing mode
 	switch inst.Mode {
 	case Immediate:
-		if len(operands) < 1 {
-			return fmt.Errorf("immediate instruction requires operand")
+		expr, ok := valueOperand(operands, patterns)
+		if !ok {
+			return internalError("immediate instruction requires operand")
 		}
-		val, err := p.evaluateExpression(operands[0])
+		val, err := p.evaluateExpression(expr)
 		if err != nil {
 			return err
 		}
 		if val < -128 || val > 255 {
-			return fmt.Errorf("immediate value out of range: %d", val)
+			return valueError(p.filename, p.statementLine, "immediate value out of range: %d", val)
 		}
 		p.assembler.emitByte(byte(val))
 
-	case ImmediateExt:
-		if len(operands) < 1 {
-			return fmt.Errorf("extended immediate instruction requires operand")
+	case ImmediatePair:
+		values := valueOperands(operands, patterns)
+		if len(values) != 2 {
+			return internalError("instruction requires two immediate operands")
+		}
+		for _, expr := range values {
+			val, err := p.evaluateExpression(expr)
+			if err != nil {
+				return err
+			}
+			if val < -128 || val > 255 {
+				return valueError(p.filename, p.statementLine, "immediate value out of range: %d", val)
+			}
+			p.assembler.emitByte(byte(val))
+		}
+
+	case ImmediateExt, ImmediateExtBE:
+		expr, ok := valueOperand(operands, patterns)
+		if !ok {
+			return internalError("extended immediate instruction requires operand")
 		}
-		val, err := p.evaluateExpression(operands[0])
+		val, err := p.evaluateExpression(expr)
 		if err != nil {
 			return err
 		}
 		if val < -32768 || val > 65535 {
-			return fmt.Errorf("extended immediate value out of range: %d", val)
+			return valueError(p.filename, p.statementLine, "extended immediate value out of range: %d", val)
+		}
+		if inst.Mode == ImmediateExtBE {
+			p.assembler.emitByte(byte(val >> 8))
+			p.assembler.emitByte(byte(val))
+		} else {
+			p.assembler.emitByte(byte(val))
+			p.assembler.emitByte(byte(val >> 8))
 		}
-		p.assembler.emitByte(byte(val))
-		p.assembler.emitByte(byte(val >> 8))
 
-	case Indexed:
-		if len(operands) < 1 {
-			return fmt.Errorf("indexed addressing requires displacement")
+	case Indexed, IndexedImmediate:
+		op, ok := indexedOperand(operands, patterns)
+		if !ok {
+			return internalError("indexed addressing requires displacement")
 		}
-		disp, err := p.extractDisplacement(operands[0])
+		disp, err := p.extractDisplacement(op)
 		if err != nil {
 			return err
 		}
 		p.assembler.emitByte(byte(disp))
 
+		// LD (IX+d),n carries its value after the displacement
+		if inst.Mode == IndexedImmediate {
+			expr, ok := valueOperand(operands, patterns)
+			if !ok {
+				return internalError("immediate instruction requires operand")
+			}
+			val, err := p.evaluateExpression(expr)
+			if err != nil {
+				return err
+			}
+			if val < -128 || val > 255 {
+				return valueError(p.filename, p.statementLine, "immediate value out of range: %d", val)
+			}
+			p.assembler.emitByte(byte(val))
+		}
+
 	case Relative:
-		if len(operands) < 1 {
-			return fmt.Errorf("relative instruction requires target")
+		expr, ok := valueOperand(operands, patterns)
+		if !ok {
+			return internalError("relative instruction requires target")
 		}
-		target, err := p.evaluateExpression(operands[0])
+		target, err := p.evaluateExpression(expr)
 		if err != nil {
 			return err
 		}
-		offset := target - (p.assembler.currentAddr + 2)
-		if offset < -128 || offset > 127 {
-			return fmt.Errorf("relative jump out of range")
+		// Targets may still be unknown in pass 1, so only the final pass checks range
+		offset := target - (start + inst.Length)
+		if p.assembler.pass == finalPass && (offset < -128 || offset > 127) {
+			return rangeError(p.filename, p.statementLine, "relative jump out of range: offset %d", offset)
 		}
 		p.assembler.emitByte(byte(offset))
 
 	case Extended:
-		if len(operands) < 1 {
-			return fmt.Errorf("extended instruction requires address")
+		expr, ok := valueOperand(operands, patterns)
+		if !ok {
+			return internalError("extended instruction requires address")
 		}
-		val, err := p.evaluateExpression(operands[0])
+		val, err := p.evaluateExpression(expr)
 		if err != nil {
 			return err
 		}
 		if val < 0 || val > 65535 {
-			return fmt.Errorf("address out of range: %d", val)
+			return valueError(p.filename, p.statementLine, "address out of range: %d", val)
 		}
 		p.assembler.emitByte(byte(val))
 		p.assembler.emitByte(byte(val >> 8))
@@ -269,26 +355,27 @@ func (p *Parser) generateInstructionCode(inst Instruction, operands []string) er
 
 // extractDisplacement extracts the displacement value from (IX+d) or (IY+d) format
 func (p *Parser) extractDisplacement(op string) (int64, error) {
-	// Remove parentheses
-	op = strings.TrimPrefix(op, "(")
-	op = strings.TrimSuffix(op, ")")
-	
-	// Find the sign position
-	signPos := strings.IndexAny(op, "+-")
-	if signPos == -1 {
-		return 0, fmt.Errorf("missing displacement in indexed addressing")
+	// Remove parentheses and the index register
+	op = strings.TrimSpace(op[1 : len(op)-1])
+	dispStr := strings.TrimSpace(op[2:])
+
+	// (IX) on its own has no displacement
+	if dispStr == "" {
+		return 0, nil
 	}
-	
-	// Extract the displacement value
-	dispStr := op[signPos:]
-	disp, err := strconv.ParseInt(dispStr, 10, 8)
+	if dispStr[0] != '+' && dispStr[0] != '-' {
+		return 0, indexedAddressError(p.filename, p.statementLine, ErrMissingDisplacement)
+	}
+
+	// The sign is part of the expression, so (IX-2+1) is IX-1
+	disp, err := p.evaluateExpression(dispStr)
 	if err != nil {
-		return 0, fmt.Errorf("invalid displacement value: %v", err)
+		return 0, err
 	}
-	
+
 	if disp < -128 || disp > 127 {
-		return 0, fmt.Errorf("displacement out of range (-128 to 127): %d", disp)
+		return 0, indexedAddressError(p.filename, p.statementLine, ErrDisplacementRange, disp)
 	}
-	
-	return disp, nil
-}
\ No newline at end of file
+
+	return int64(disp), nil
+}
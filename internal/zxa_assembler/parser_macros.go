@@ -0,0 +1,351 @@
+// file: internal/zxa_assembler/parser_macros.go
+
+package zxa_assembler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxMacroDepth limits nested macro expansion, which catches a macro that
+// calls itself without end
+const maxMacroDepth = 64
+
+// macroParam is one parameter of a macro, with its optional default value
+type macroParam struct {
+	name       string
+	value      string
+	hasDefault bool
+}
+
+// Macro is a macro definition. The body is kept as source text and
+// assembled afresh on every expansion.
+type Macro struct {
+	Name   string
+	File   string // File holding the definition
+	Line   int    // Line of the MACRO directive
+	params []macroParam
+	body   []string
+}
+
+// parseMACRO handles MACRO name [param[=default], ...] and collects the
+// body up to the matching ENDM
+func (p *Parser) parseMACRO(line int) error {
+	name, err := p.nextToken()
+	if err != nil {
+		return err
+	}
+	if name.Type != TokenIdentifier {
+		if !isEndOfLine(name) {
+			p.unread(name)
+		}
+		return directiveError(p.filename, line, "MACRO requires a name")
+	}
+
+	operands, err := p.readOperands(line)
+	if err != nil {
+		return err
+	}
+
+	// A bad parameter is reported after the body has been read, so the body
+	// is not assembled as if it stood outside the macro
+	macro := &Macro{Name: name.Value, File: p.filename, Line: line}
+	var paramErr error
+	seen := make(map[string]bool)
+	for _, op := range operands {
+		param, value, hasDefault := strings.Cut(op, "=")
+		param = strings.TrimSpace(param)
+		switch {
+		case !isSymbolName(param):
+			paramErr = directiveError(p.filename, line, "invalid macro parameter: %s", op)
+		case seen[param]:
+			paramErr = directiveError(p.filename, line, "duplicate macro parameter: %s", param)
+		}
+		seen[param] = true
+		macro.params = append(macro.params, macroParam{param, strings.TrimSpace(value), hasDefault})
+	}
+
+	// The body is read as raw lines, since it only makes sense once expanded
+	if err := p.endLine(); err != nil {
+		return err
+	}
+	body, ok := p.readBody([]string{"MACRO"}, []string{"ENDM"})
+	if !ok {
+		return directiveError(p.filename, line, "MACRO %s without ENDM", macro.Name)
+	}
+	macro.body = body
+	if paramErr != nil {
+		return paramErr
+	}
+
+	key := strings.ToUpper(macro.Name)
+	if prev, exists := p.assembler.macros[key]; exists {
+		return directiveError(p.filename, line, "macro %s already defined at %s:%d",
+			macro.Name, prev.File, prev.Line)
+	}
+	p.assembler.macros[key] = macro
+	return nil
+}
+
+// readBody reads the raw lines of a block up to the directive that closes
+// it, counting nested blocks opened by any of opens. The end of line of the
+// closing directive is left for parseLine.
+func (p *Parser) readBody(opens, closes []string) ([]string, bool) {
+	var body []string
+	depth := 1
+	for {
+		text, ok := p.readRawLine()
+		if !ok {
+			return body, false
+		}
+		word := firstWord(text)
+		switch {
+		case containsString(opens, word):
+			depth++
+		case containsString(closes, word):
+			depth--
+		}
+		if depth == 0 {
+			p.unread(Token{TokenEOL, "", p.line - 1, 0})
+			return body, true
+		}
+		body = append(body, text)
+	}
+}
+
+// readRawLine returns the next line of input without tokenizing it
+func (p *Parser) readRawLine() (string, bool) {
+	if p.pos >= len(p.input) {
+		return "", false
+	}
+	start := p.pos
+	for p.pos < len(p.input) && p.input[p.pos] != '\n' {
+		p.pos++
+	}
+	text := p.input[start:p.pos]
+	if p.pos < len(p.input) {
+		p.pos++
+	}
+	p.line++
+	p.column = 1
+	return text, true
+}
+
+// firstWord returns the statement keyword of a raw line in upper case,
+// skipping a leading label
+func firstWord(text string) string {
+	fields := strings.Fields(stripComment(text))
+	if len(fields) > 0 && strings.HasSuffix(fields[0], ":") {
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToUpper(fields[0])
+}
+
+// lookupMacro returns the macro a statement keyword calls, if any
+func (p *Parser) lookupMacro(name string) (*Macro, bool) {
+	macro, ok := p.assembler.macros[strings.ToUpper(name)]
+	return macro, ok
+}
+
+// expandMacro assembles the body of a macro with the call's arguments.
+// Errors inside the body are reported at their definition line, with the
+// call site in the include chain.
+func (p *Parser) expandMacro(macro *Macro, token Token) error {
+	args, err := p.readOperands(token.Line)
+	if err != nil {
+		return err
+	}
+	values, err := p.bindMacroArgs(macro, args, token.Line)
+	if err != nil {
+		return err
+	}
+
+	a := p.assembler
+	if len(a.macroStack) >= maxMacroDepth {
+		return directiveError(p.filename, token.Line,
+			"macro expansion nested too deeply (limit %d) in %s", maxMacroDepth, macro.Name)
+	}
+
+	// Labels declared LOCAL, and .local labels, get a name of their own in
+	// every expansion
+	a.expansions++
+	for _, name := range bodyLabels(macro.body) {
+		if strings.HasPrefix(name, ".") {
+			values[name] = a.uniqueName(name)
+		}
+	}
+	body := make([]string, len(macro.body))
+	for i, text := range macro.body {
+		if firstWord(text) == "LOCAL" {
+			code := stripComment(text)
+			names := code[strings.Index(strings.ToUpper(code), "LOCAL")+len("LOCAL"):]
+			for _, name := range strings.Split(names, ",") {
+				name = strings.TrimSpace(name)
+				if !isSymbolName(name) {
+					return directiveError(macro.File, macro.Line+1+i, "invalid LOCAL label: %s", name)
+				}
+				values[name] = a.uniqueName(name)
+			}
+			continue
+		}
+		body[i] = text
+	}
+	for i, text := range body {
+		body[i] = substituteWords(text, values)
+	}
+
+	call := SourceLocation{File: p.filename, Line: token.Line, Macro: macro.Name}
+	a.includeStack = append(a.includeStack, call)
+	a.macroStack = append(a.macroStack, macro.Name)
+	defer func() {
+		a.includeStack = a.includeStack[:len(a.includeStack)-1]
+		a.macroStack = a.macroStack[:len(a.macroStack)-1]
+	}()
+
+	parser := NewParser(strings.Join(body, "\n"), p.debug)
+	parser.assembler = a
+	parser.filename = macro.File
+	parser.line = macro.Line + 1
+	parser.expansion = true
+	parser.parseAll()
+
+	return nil
+}
+
+// uniqueName returns the name a label of a macro or repeat body takes in
+// the current expansion, such as loop__3. These names are internal and
+// are left out of the symbol file and report; a source label that takes
+// one anyway is reported as a duplicate that names the expansion.
+func (a *Assembler) uniqueName(name string) string {
+	unique := fmt.Sprintf("%s__%d", name, a.expansions)
+	a.generated[strings.TrimPrefix(unique, ".")] = true
+	return unique
+}
+
+// bindMacroArgs matches call arguments to parameters. Arguments are taken
+// in order until one is written name=value, which sets that parameter.
+func (p *Parser) bindMacroArgs(macro *Macro, args []string, line int) (map[string]string, error) {
+	values := make(map[string]string)
+	set := make(map[string]bool)
+
+	for i, arg := range args {
+		if name, value, ok := strings.Cut(arg, "="); ok && macro.hasParam(strings.TrimSpace(name)) &&
+			!strings.HasPrefix(value, "=") {
+			name = strings.TrimSpace(name)
+			if set[name] {
+				return nil, directiveError(p.filename, line, "argument %s of macro %s given twice", name, macro.Name)
+			}
+			values[name], set[name] = strings.TrimSpace(value), true
+			continue
+		}
+		if i >= len(macro.params) {
+			return nil, directiveError(p.filename, line, "too many arguments for macro %s (expects %d)",
+				macro.Name, len(macro.params))
+		}
+		name := macro.params[i].name
+		if set[name] {
+			return nil, directiveError(p.filename, line, "argument %s of macro %s given twice", name, macro.Name)
+		}
+		values[name], set[name] = arg, true
+	}
+
+	for _, param := range macro.params {
+		if set[param.name] {
+			continue
+		}
+		if !param.hasDefault {
+			return nil, directiveError(p.filename, line, "missing argument %s for macro %s (defined at %s:%d)",
+				param.name, macro.Name, macro.File, macro.Line)
+		}
+		values[param.name] = param.value
+	}
+	return values, nil
+}
+
+// hasParam reports whether the macro has a parameter of the given name
+func (m *Macro) hasParam(name string) bool {
+	for _, param := range m.params {
+		if param.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// substituteWords replaces whole identifiers in a line of source, leaving
+// strings, comments and numbers untouched
+func substituteWords(text string, values map[string]string) string {
+	var sb strings.Builder
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ';':
+			sb.WriteString(text[i:])
+			return sb.String()
+
+		case c == '"' || c == '\'':
+			j := quoteEnd(text, i)
+			sb.WriteString(text[i:j])
+			i = j
+
+		case isAlpha(rune(c)) || c == '.' && i+1 < len(text) && isAlpha(rune(text[i+1])):
+			// Dotted names such as .loop or routine.loop are single words
+			j := i + 1
+			for j < len(text) && (isAlphaNum(rune(text[j])) || text[j] == '.') {
+				j++
+			}
+			word := text[i:j]
+			if value, ok := values[word]; ok {
+				word = value
+			}
+			sb.WriteString(word)
+			i = j
+
+		case isDigit(rune(c)) || c == '$':
+			// Numbers such as 0FFh or $CAFE are never parameter names
+			j := i + 1
+			for j < len(text) && isAlphaNum(rune(text[j])) {
+				j++
+			}
+			sb.WriteString(text[i:j])
+			i = j
+
+		default:
+			sb.WriteByte(c)
+			i++
+		}
+	}
+	return sb.String()
+}
+
+// quoteEnd returns where the string or character constant starting at
+// text[i] ends. A quote without its partner, as in AF', is an ordinary
+// character, while an unclosed string runs to the end of the line.
+func quoteEnd(text string, i int) int {
+	if length := quotedLength(text[i:]); length > 0 {
+		return i + length
+	}
+	if text[i] == '\'' {
+		return i + 1
+	}
+	return len(text)
+}
+
+// stripComment returns a raw line without its comment. A ; inside a string
+// or character constant does not start one.
+func stripComment(text string) string {
+	for i := 0; i < len(text); {
+		switch text[i] {
+		case ';':
+			return text[:i]
+		case '"', '\'':
+			i = quoteEnd(text, i)
+		default:
+			i++
+		}
+	}
+	return text
+}
@@ -0,0 +1,107 @@
+// file: internal/zxa_assembler/parser_macros_test.go
+
+package zxa_assembler
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMacros(t *testing.T) {
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{
+			name: "parameters",
+			src:  " MACRO store reg, addr\n ld (addr), reg\n ENDM\n store a, $4000\n",
+			want: []byte{0x32, 0x00, 0x40},
+		},
+		{
+			name: "default value",
+			src:  " MACRO fill v=$FF\n DEFB v\n ENDM\n fill\n fill 1\n",
+			want: []byte{0xFF, 0x01},
+		},
+		{
+			name: "named argument",
+			src:  " MACRO pair a1, a2=0\n DEFB a1, a2\n ENDM\n pair a2=5, a1=4\n",
+			want: []byte{4, 5},
+		},
+		{
+			name: "LOCAL labels differ per expansion",
+			src:  " ORG $8000\n MACRO wait\n LOCAL loop\nloop: djnz loop\n ENDM\n wait\n wait\n",
+			want: []byte{0x10, 0xFE, 0x10, 0xFE},
+		},
+		{
+			name: ".local labels differ per expansion",
+			src:  " ORG $8000\n MACRO skip\n jr .over\n nop\n.over:\n ENDM\nstart: skip\n skip\n",
+			want: []byte{0x18, 0x01, 0x00, 0x18, 0x01, 0x00},
+		},
+		{
+			name: "nested macros",
+			src:  " MACRO one\n DEFB 1\n ENDM\n MACRO two\n one\n one\n ENDM\n two\n",
+			want: []byte{1, 1},
+		},
+		{
+			name: "parameter not replaced inside strings",
+			src:  " MACRO text v\n DEFB \"v\", v\n ENDM\n text 1\n",
+			want: []byte{'v', 1},
+		},
+		{
+			name: "semicolons inside strings",
+			src:  " MACRO m\n DEFB \";ENDM\", ';'\nlab: DEFB \"a;b\" ; ENDM\n ENDM\n ORG $8000\n m\n",
+			want: []byte{';', 'E', 'N', 'D', 'M', ';', 'a', ';', 'b'},
+		},
+		{
+			name:    "label named like an expansion label",
+			src:     " MACRO m\n LOCAL x\nx: nop\n ENDM\n m\nx__1: nop\n",
+			wantErr: "duplicate symbol: x__1 (also the name a macro or repeat expansion gives a label)",
+		},
+		{name: "missing argument", src: " MACRO m v\n DEFB v\n ENDM\n m\n", wantErr: "missing argument v for macro m"},
+		{name: "too many arguments", src: " MACRO m v\n DEFB v\n ENDM\n m 1, 2\n", wantErr: "too many arguments for macro m"},
+		{name: "without ENDM", src: " MACRO m\n nop\n", wantErr: "MACRO m without ENDM"},
+		{name: "ENDM without MACRO", src: " ENDM\n", wantErr: "ENDM without MACRO"},
+		{name: "redefined", src: " MACRO m\n ENDM\n MACRO m\n ENDM\n", wantErr: "macro m already defined"},
+		{name: "endless recursion", src: " MACRO m\n m\n ENDM\n m\n", wantErr: "macro expansion nested too deeply"},
+	})
+}
+
+func TestMacroCallInErrors(t *testing.T) {
+	src := " MACRO load v\n ld a, v\n ENDM\n load 1\n load 999\n"
+	_, _, err := assembleSource(t, src)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "main.asm:2") || !strings.Contains(msg, "in macro load called at") ||
+		!strings.Contains(msg, "main.asm:5") {
+		t.Errorf("unexpected message: %s", msg)
+	}
+}
+
+func TestReportLeavesOutMacroLabels(t *testing.T) {
+	src := " MACRO wait\n LOCAL again\nagain: djnz again\n ENDM\n ORG $8000\nstart: wait\n wait\n"
+	dir := writeFiles(t, map[string]string{"main.asm": src})
+	a := NewAssembler(AssemblerOptions{})
+	a.SetJSONOutput(true)
+	result, err := a.Assemble(filepath.Join(dir, "main.asm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(result.JSONReport, "again") || !strings.Contains(result.JSONReport, "start") {
+		t.Errorf("report should list start only:\n%s", result.JSONReport)
+	}
+	checkSymbols(t, a, map[string]int{"again__1": 0x8000, "again__2": 0x8002})
+}
+
+func TestStripComment(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{` DB ";ENDM" ; note`, ` DB ";ENDM" `},
+		{`lab: cp ';' ; semicolon`, `lab: cp ';' `},
+		{` ex af, af' ; swap`, ` ex af, af' `},
+		{` DB "open;`, ` DB "open;`},
+	}
+	for _, tc := range tests {
+		if got := stripComment(tc.in); got != tc.want {
+			t.Errorf("stripComment(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// file: internal/zxa_assembler/parser_messages.go
+
+package zxa_assembler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseASSERT handles ASSERT expr [,"message"]. The condition is checked in
+// the final pass, so it may refer to symbols defined further down.
+func (p *Parser) parseASSERT(line int) error {
+	operands, err := p.readOperands(line)
+	if err != nil {
+		return err
+	}
+	if len(operands) < 1 || len(operands) > 2 {
+		return directiveError(p.filename, line, "ASSERT requires a condition and optional message")
+	}
+	text := ""
+	if len(operands) == 2 {
+		if text, err = p.messageText(operands[1:], line); err != nil {
+			return err
+		}
+	}
+	if p.assembler.pass != finalPass {
+		return nil
+	}
+
+	cond, err := p.evaluateExpression(operands[0])
+	if err != nil {
+		return err
+	}
+	if cond != 0 {
+		return nil
+	}
+	if text == "" {
+		return assertionError(p.filename, line, "%s", operands[0])
+	}
+	return assertionError(p.filename, line, "%s (%s)", text, operands[0])
+}
+
+// parseMessage handles DISPLAY, WARNING and ERROR, whose operands are
+// strings and expressions printed one after the other. ERROR fails the
+// assembly; the others are reported with the result. Only the final pass
+// reports them, when every value is known.
+func (p *Parser) parseMessage(directive string, line int) error {
+	operands, err := p.readOperands(line)
+	if err != nil {
+		return err
+	}
+	if len(operands) == 0 && directive == "DISPLAY" {
+		return directiveError(p.filename, line, "DISPLAY requires a message")
+	}
+	if p.assembler.pass != finalPass {
+		return nil
+	}
+
+	text, err := p.messageText(operands, line)
+	if err != nil {
+		return err
+	}
+	switch directive {
+	case "ERROR":
+		if text == "" {
+			text = "ERROR directive"
+		}
+		return message(ErrUser, p.filename, line, text)
+	case "WARNING":
+		p.assembler.report(p.diagnostic(message(Warning, p.filename, line, text)))
+	default:
+		p.assembler.report(p.diagnostic(message(Note, p.filename, line, text)))
+	}
+	return nil
+}
+
+// messageText joins the operands of a message: strings as written and
+// expressions as their value in decimal and hex. Outside the final pass
+// expressions are not evaluated, as their symbols may not be known yet.
+func (p *Parser) messageText(operands []string, line int) (string, error) {
+	var sb strings.Builder
+	for _, op := range operands {
+		if str, ok := stringOperand(op); ok {
+			chars, err := decodeString(str)
+			if err != nil {
+				return "", valueError(p.filename, line, "%v in string \"%s\"", err, str)
+			}
+			sb.WriteString(plainText(chars))
+			continue
+		}
+		if p.assembler.pass != finalPass {
+			continue
+		}
+		value, err := p.evaluateExpression(op)
+		if err != nil {
+			return "", err
+		}
+		if value < -0x8000 || value > 0xFFFF {
+			fmt.Fprintf(&sb, "%d", value)
+		} else {
+			fmt.Fprintf(&sb, "%d ($%04X)", value, uint16(value))
+		}
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,46 @@
+// file: internal/zxa_assembler/parser_messages_test.go
+
+package zxa_assembler
+
+import "testing"
+
+func TestASSERT(t *testing.T) {
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{name: "holds", src: " ORG $8000\n nop\n ASSERT $ == $8001\n", want: []byte{0}},
+		{name: "forward symbol", src: " ASSERT size < 4\n DB 1\nsize EQU 2\n", want: []byte{1}},
+		{name: "fails", src: " ASSERT 1 == 2\n", wantErr: "assertion failed: 1==2"},
+		{name: "fails with message", src: " ASSERT 0, \"too big\"\n", wantErr: "assertion failed: too big (0)"},
+		{name: "no condition", src: " ASSERT\n", wantErr: "ASSERT requires a condition"},
+	})
+}
+
+func TestMessages(t *testing.T) {
+	src := "v EQU 10\n DISPLAY \"v is \", v\n WARNING \"careful\"\n nop\n"
+	a, _, err := assembleSource(t, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := a.Messages()
+	want := []struct {
+		category ErrorCategory
+		text     string
+		line     int
+	}{
+		{Note, "v is 10 ($000A)", 2},
+		{Warning, "careful", 3},
+	}
+	if len(msgs) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(msgs), len(want))
+	}
+	for i, w := range want {
+		if msgs[i].Category != w.category || msgs[i].Message != w.text || msgs[i].Line != w.line {
+			t.Errorf("message %d: got %s %q at line %d", i, msgs[i].Category, msgs[i].Message, msgs[i].Line)
+		}
+	}
+
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{name: "ERROR", src: " ERROR \"stop: \", 3\n", wantErr: "error: stop: 3 ($0003)"},
+		{name: "ERROR without text", src: " ERROR\n", wantErr: "ERROR directive"},
+		{name: "DISPLAY without text", src: " DISPLAY\n", wantErr: "DISPLAY requires a message"},
+	})
+}
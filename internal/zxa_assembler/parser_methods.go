@@ -25,7 +25,7 @@ func (p *Parser) skipComment() {
 
 // isEOF checks if we've reached the end of input
 func (p *Parser) isEOF() bool {
-	return p.pos >= len(p.input)
+	return p.pos >= len(p.input) && len(p.tokens) == 0
 }
 
 // readIdentifier reads an identifier token
@@ -33,7 +33,15 @@ func (p *Parser) readIdentifier() (Token, error) {
 	start := p.pos
 	startCol := p.column
 
-	for p.pos < len(p.input) && isAlphaNum(rune(p.input[p.pos])) {
+	// Dots join qualified names such as routine.loop; @ marks a global name
+	for p.pos < len(p.input) && (isAlphaNum(rune(p.input[p.pos])) || p.input[p.pos] == '.' ||
+		p.pos == start && p.input[p.pos] == '@') {
+		p.pos++
+		p.column++
+	}
+
+	// The alternate register pair is written AF'
+	if strings.EqualFold(p.input[start:p.pos], "AF") && p.pos < len(p.input) && p.input[p.pos] == '\'' {
 		p.pos++
 		p.column++
 	}
@@ -85,7 +93,6 @@ func (p *Parser) readIdentifier() (Token, error) {
 	return Token{TokenIdentifier, value, p.line, startCol}, nil
 }
 
-
 // readString reads a string token
 func (p *Parser) readString() (Token, error) {
 	startCol := p.column
@@ -93,8 +100,9 @@ func (p *Parser) readString() (Token, error) {
 	p.column++
 
 	start := p.pos
-	for p.pos < len(p.input) && p.input[p.pos] != '"' {
-		if p.input[p.pos] == '\\' {
+	// A string cannot run past the end of its line
+	for p.pos < len(p.input) && p.input[p.pos] != '"' && p.input[p.pos] != '\n' {
+		if p.input[p.pos] == '\\' && p.pos+1 < len(p.input) && p.input[p.pos+1] != '\n' {
 			p.pos += 2
 			p.column += 2
 		} else {
@@ -103,8 +111,8 @@ func (p *Parser) readString() (Token, error) {
 		}
 	}
 
-	if p.pos >= len(p.input) {
-		return Token{}, fmt.Errorf("unterminated string at line %d", p.line)
+	if p.pos >= len(p.input) || p.input[p.pos] != '"' {
+		return Token{}, syntaxError(p.filename, p.line, startCol, "unterminated string")
 	}
 
 	value := p.input[start:p.pos]
@@ -118,8 +126,22 @@ func (p *Parser) readString() (Token, error) {
 	return Token{TokenString, value, p.line, startCol}, nil
 }
 
+// readChar reads a character constant such as 'A' or '\n'. It is kept
+// with its quotes as a number token and decoded by the expression parser.
+func (p *Parser) readChar() (Token, error) {
+	startCol := p.column
+	length := quotedLength(p.input[p.pos:])
+	if length < 0 {
+		return Token{}, syntaxError(p.filename, p.line, startCol, "unterminated character constant")
+	}
+
+	value := p.input[p.pos : p.pos+length]
+	p.pos += length
+	p.column += length
+
+	return Token{TokenNumber, value, p.line, startCol}, nil
+}
 
 func (p *Parser) isInstruction(s string) bool {
-	_, ok := p.assembler.instructions[strings.ToUpper(s)]
-	return ok
-}
\ No newline at end of file
+	return p.assembler.mnemonics[strings.ToUpper(s)]
+}
@@ -0,0 +1,135 @@
+// file: internal/zxa_assembler/parser_modules.go
+
+package zxa_assembler
+
+import "strings"
+
+// module is one MODULE ... ENDMODULE block being assembled
+type module struct {
+	name string
+	from SourceLocation // The MODULE directive, for unterminated blocks
+}
+
+// parseMODULE handles MODULE name. Symbols defined up to the matching
+// ENDMODULE are prefixed with the module name, as in sound.init.
+func (p *Parser) parseMODULE(line int) error {
+	operands, err := p.readOperands(line)
+	if err != nil {
+		return err
+	}
+	if len(operands) != 1 || !isSymbolName(operands[0]) {
+		return directiveError(p.filename, line, "MODULE requires a name")
+	}
+
+	a := p.assembler
+	a.modules = append(a.modules, module{operands[0], SourceLocation{File: p.filename, Line: line}})
+	a.currentLabel = ""
+	return nil
+}
+
+// parseENDMODULE handles ENDMODULE, closing the innermost module
+func (p *Parser) parseENDMODULE(line int) error {
+	a := p.assembler
+	if len(a.modules) == 0 {
+		return directiveError(p.filename, line, "ENDMODULE without MODULE")
+	}
+	a.modules = a.modules[:len(a.modules)-1]
+	a.currentLabel = ""
+	return nil
+}
+
+// closeModules closes the innermost modules opened in file, which END
+// finishes early. A module opened by an including file stays open.
+func (a *Assembler) closeModules(file string) {
+	for len(a.modules) > 0 && a.modules[len(a.modules)-1].from.File == file {
+		a.modules = a.modules[:len(a.modules)-1]
+		a.currentLabel = ""
+	}
+}
+
+// modulePrefix returns the prefix given to names defined in the current
+// module, such as "game.sound." inside a nested module
+func (a *Assembler) modulePrefix() string {
+	var sb strings.Builder
+	for _, m := range a.modules {
+		sb.WriteString(m.name)
+		sb.WriteByte('.')
+	}
+	return sb.String()
+}
+
+// checkModules reports modules still open at the end of the source
+func (a *Assembler) checkModules() {
+	for _, m := range a.modules {
+		a.errors.Add(directiveError(m.from.File, m.from.Line, "MODULE %s without ENDMODULE", m.name))
+	}
+	a.modules = nil
+}
+
+// qualify returns the full name a label is defined under. A local name
+// such as .loop belongs to the last non-local label, as routine.loop; any
+// other name belongs to the current module unless written @name.
+func (p *Parser) qualify(name string) string {
+	switch {
+	case strings.HasPrefix(name, "."):
+		return p.assembler.currentLabel + name
+	case strings.HasPrefix(name, "@"):
+		return name[1:]
+	default:
+		return p.assembler.modulePrefix() + name
+	}
+}
+
+// candidates returns the names a symbol reference may stand for, in the
+// order they are tried: the current module first, then each enclosing
+// module, then the global name
+func (p *Parser) candidates(name string) []string {
+	if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "@") {
+		return []string{p.qualify(name)}
+	}
+	a := p.assembler
+	names := make([]string, 0, len(a.modules)+1)
+	for i := len(a.modules); i > 0; i-- {
+		var sb strings.Builder
+		for _, m := range a.modules[:i] {
+			sb.WriteString(m.name)
+			sb.WriteByte('.')
+		}
+		names = append(names, sb.String()+name)
+	}
+	return append(names, name)
+}
+
+// lookupSymbol resolves a symbol reference from the current module
+func (p *Parser) lookupSymbol(name string) (Symbol, bool) {
+	for _, full := range p.candidates(name) {
+		if sym, exists := p.assembler.symbols[full]; exists {
+			return sym, true
+		}
+	}
+	return Symbol{}, false
+}
+
+// isSymbolDefined reports whether a reference resolves to a symbol defined
+// earlier in this pass, as IFDEF sees it
+func (p *Parser) isSymbolDefined(name string) bool {
+	for _, full := range p.candidates(name) {
+		if p.assembler.isDefined(full) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLabelName reports whether s can name a symbol in a reference: a plain,
+// local, global or module-qualified name
+func isLabelName(s string) bool {
+	s = strings.TrimPrefix(s, "@")
+	s = strings.TrimPrefix(s, ".")
+	for _, part := range strings.Split(s, ".") {
+		if !isSymbolName(part) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,32 @@
+// file: internal/zxa_assembler/parser_modules_test.go
+
+package zxa_assembler
+
+import "testing"
+
+func TestModules(t *testing.T) {
+	src := ` ORG $8000
+count EQU 1
+ MODULE sound
+count EQU 2
+play: ld a, count
+ ld b, @count
+ MODULE fx
+beep: jp play
+ ENDMODULE
+ ENDMODULE
+ call sound.play
+ call sound.fx.beep
+`
+	a, result, err := assembleSource(t, src)
+	want := []byte{0x3E, 0x02, 0x06, 0x01, 0xC3, 0x00, 0x80, 0xCD, 0x00, 0x80, 0xCD, 0x04, 0x80}
+	checkResult(t, result, err, want, "")
+	checkSymbols(t, a, map[string]int{"count": 1, "sound.count": 2, "sound.play": 0x8000, "sound.fx.beep": 0x8004})
+
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{name: "unclosed", src: " MODULE m\n nop\n", wantErr: "MODULE m without ENDMODULE"},
+		{name: "ENDMODULE alone", src: " ENDMODULE\n", wantErr: "ENDMODULE without MODULE"},
+		{name: "invalid name", src: " MODULE 1x\n ENDMODULE\n", wantErr: "MODULE"},
+		{name: "private to the module", src: " MODULE m\nx: nop\n ENDMODULE\n jp x\n", wantErr: "undefined symbol: x"},
+	})
+}
@@ -3,6 +3,7 @@
 package zxa_assembler
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -10,12 +11,12 @@ import (
 
 // Number formats supported by the assembler
 const (
-	fmtDecimal = iota
-	fmtHexDollar    // $NNNN format
-	fmtHexC         // 0xNNNN format
-	fmtHexSuffix    // NNNNh format
+	fmtDecimal       = iota
+	fmtHexDollar     // $NNNN format
+	fmtHexC          // 0xNNNN format
+	fmtHexSuffix     // NNNNh format
 	fmtBinaryPercent // %NNNN format
-	fmtBinaryC      // 0bNNNN format
+	fmtBinaryC       // 0bNNNN format
 )
 
 // parseNumber takes a string and determines its format and value
@@ -80,8 +81,8 @@ func parseNumber(s string) (int64, error) {
 		base = 2
 	}
 
-	// Parse the value
-	val, err := strconv.ParseInt(numStr, base, 32)
+	// Parse the value; 33 bits leave room for DD $FFFFFFFF
+	val, err := strconv.ParseInt(numStr, base, 33)
 	if err != nil {
 		switch format {
 		case fmtDecimal:
@@ -114,7 +115,6 @@ func formatNumber(val int64, format int) string {
 	}
 }
 
-
 // validateNumberString validates a number string based on its format
 func validateNumberString(s string) error {
 	if s == "" {
@@ -187,9 +187,6 @@ func isValidBinaryDigit(c byte) bool {
 	return c == '0' || c == '1'
 }
 
-
-
-
 // readNumber reads a numeric token
 func (p *Parser) readNumber() (Token, error) {
 	start := p.pos
@@ -211,7 +208,8 @@ func (p *Parser) readNumber() (Token, error) {
 				isHex = true
 				p.pos += 2
 				p.column += 2
-			} else if p.input[p.pos:p.pos+2] == "0b" {
+			} else if p.input[p.pos:p.pos+2] == "0b" && p.pos+2 < len(p.input) && isValidBinaryDigit(p.input[p.pos+2]) {
+				// Without digits 0b is a backward reference to the anonymous label 0:
 				isBin = true
 				p.pos += 2
 				p.column += 2
@@ -220,6 +218,11 @@ func (p *Parser) readNumber() (Token, error) {
 	}
 
 	numberStart := p.pos
+	// A lone $ is the current address
+	if isHex && start == numberStart-1 && (p.pos >= len(p.input) || !isValidHexDigit(p.input[p.pos])) {
+		return Token{TokenNumber, "$", p.line, startCol}, nil
+	}
+
 	// Read the number part
 	for p.pos < len(p.input) {
 		c := rune(p.input[p.pos])
@@ -237,7 +240,8 @@ func (p *Parser) readNumber() (Token, error) {
 				break
 			}
 		} else {
-			if !isDigit(c) {
+			// Letters belong to suffixed forms such as 0FFh
+			if !isAlphaNum(c) {
 				break
 			}
 		}
@@ -247,7 +251,7 @@ func (p *Parser) readNumber() (Token, error) {
 	}
 
 	if p.pos <= numberStart {
-		return Token{}, fmt.Errorf("empty number at line %d, column %d", p.line, p.column)
+		return Token{}, syntaxError(p.filename, p.line, startCol, "empty number")
 	}
 
 	value := p.input[start:p.pos]
@@ -258,70 +262,56 @@ func (p *Parser) readNumber() (Token, error) {
 	return Token{TokenNumber, value, p.line, startCol}, nil
 }
 
-
-// evaluateExpression evaluates numeric expressions with various prefixes
+// evaluateExpression evaluates an operand expression. Symbols not defined
+// yet evaluate to zero during the first pass so the statement can still be
+// sized; by the final pass every symbol must exist.
 func (p *Parser) evaluateExpression(expr string) (int, error) {
-	expr = strings.TrimSpace(expr)
-
-	if p.debug {
-		fmt.Printf("DEBUG: evaluateExpression: expr='%s'\n", expr)
-	}
-
-	// Handle hex values (both $FFFF and 0xFFFF format)
-	if strings.HasPrefix(expr, "$") {
-		hex := strings.TrimPrefix(expr, "$")
-		val, err := strconv.ParseInt(hex, 16, 32)
-		if err != nil {
-			return 0, err
-		}
-		return int(val), nil
+	val, undefined, err := p.evaluate(expr)
+	if err != nil {
+		return 0, err
 	}
-	if strings.HasPrefix(expr, "0x") {
-		hex := strings.TrimPrefix(expr, "0x")
-		val, err := strconv.ParseInt(hex, 16, 32)
-		if err != nil {
-			return 0, err
-		}
-		return int(val), nil
-	}
-
-	// Handle hex values with h suffix
-	if strings.HasSuffix(expr, "h") || strings.HasSuffix(expr, "H") {
-		hex := strings.TrimSuffix(strings.TrimSuffix(expr, "h"), "H")
-		val, err := strconv.ParseInt(hex, 16, 32)
-		if err != nil {
-			return 0, err
-		}
-		return int(val), nil
+	if undefined != "" && p.assembler.pass == finalPass {
+		return 0, symbolError(p.filename, p.statementLine, "undefined symbol: %s", undefined)
 	}
+	return val, nil
+}
 
-	// Handle binary values (both %1010 and 0b1010 format)
-	if strings.HasPrefix(expr, "%") {
-		bin := strings.TrimPrefix(expr, "%")
-		val, err := strconv.ParseInt(bin, 2, 32)
-		if err != nil {
-			return 0, err
-		}
-		return int(val), nil
+// evaluateResolved evaluates an expression whose value is needed during the
+// first pass, such as an ORG address or a DEFS size, so it may not refer
+// to symbols defined further down the source
+func (p *Parser) evaluateResolved(expr string) (int, error) {
+	val, undefined, err := p.evaluate(expr)
+	if err != nil {
+		return 0, err
 	}
-	if strings.HasPrefix(expr, "0b") {
-		bin := strings.TrimPrefix(expr, "0b")
-		val, err := strconv.ParseInt(bin, 2, 32)
-		if err != nil {
-			return 0, err
-		}
-		return int(val), nil
+	if undefined != "" {
+		return 0, symbolError(p.filename, p.statementLine,
+			"undefined symbol: %s (forward references are not allowed here)", undefined)
 	}
+	return val, nil
+}
+
+// evaluate evaluates an expression of numbers, symbols, $ and operators.
+// The name of the first symbol that is not defined yet is returned with a
+// zero value, so placeholders never leak into range checks.
+func (p *Parser) evaluate(expr string) (int, string, error) {
+	expr = strings.TrimSpace(expr)
 
-	// Handle symbols
-	if sym, exists := p.assembler.symbols[expr]; exists {
-		return sym.Value, nil
+	if p.debug {
+		fmt.Printf("DEBUG: evaluateExpression: expr='%s'\n", expr)
 	}
 
-	// Default to decimal
-	val, err := strconv.ParseInt(expr, 10, 32)
+	e := &exprParser{parser: p, input: expr}
+	val, err := e.parse()
 	if err != nil {
-		return 0, err
+		var diag AssemblerError
+		if !errors.As(err, &diag) {
+			err = syntaxError(p.filename, p.statementLine, p.statementCol, "%v", err)
+		}
+		return 0, "", err
+	}
+	if e.undefined != "" {
+		return 0, e.undefined, nil
 	}
-	return int(val), nil
+	return int(val), "", nil
 }
@@ -0,0 +1,185 @@
+// file: internal/zxa_assembler/parser_repeat.go
+
+package zxa_assembler
+
+import "strings"
+
+// maxIterations limits REPT counts and WHILE loops, which catches a WHILE
+// whose condition never becomes false
+const maxIterations = 65536
+
+// Directives that open and close repeated blocks; DUP/EDUP are the
+// sjasmplus spellings of REPT/ENDR
+var (
+	repeatOpens  = []string{"REPT", "DUP", "WHILE"}
+	repeatCloses = []string{"ENDR", "EDUP", "ENDW"}
+)
+
+// readRepeat reads the operands and body of a REPT or WHILE block. The
+// body is read even when the operands are wrong, so that it is not
+// assembled as if it stood outside the block.
+func (p *Parser) readRepeat(directive string, line int) ([]string, []string, error) {
+	operands, opErr := p.readOperands(line)
+	if opErr != nil {
+		p.discardOperands()
+	}
+	if err := p.endLine(); err != nil {
+		return nil, nil, err
+	}
+
+	body, ok := p.readBody(repeatOpens, repeatCloses)
+	if !ok {
+		return nil, nil, directiveError(p.filename, line, "%s without %s", directive,
+			repeatCloses[indexString(repeatOpens, directive)])
+	}
+	if opErr != nil {
+		return nil, nil, opErr
+	}
+	return operands, body, nil
+}
+
+// parseREPT handles REPT count [,counter] ... ENDR, also written DUP ... EDUP.
+// The counter symbol runs from 0 to count-1.
+func (p *Parser) parseREPT(directive string, line int) error {
+	operands, body, err := p.readRepeat(directive, line)
+	if err != nil {
+		return err
+	}
+	if len(operands) < 1 || len(operands) > 2 {
+		return directiveError(p.filename, line, "%s requires a count and optional counter", directive)
+	}
+
+	count, err := p.evaluateResolved(operands[0])
+	if err != nil {
+		return err
+	}
+	if count < 0 || count > maxIterations {
+		return valueError(p.filename, line, "%s count out of range (0 to %d): %d",
+			directive, maxIterations, count)
+	}
+	counter, err := p.repeatCounter(operands, line)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < count; i++ {
+		if !p.runIteration(body, line, counter, i) {
+			break
+		}
+	}
+	return nil
+}
+
+// parseWHILE handles WHILE expr [,counter] ... ENDW. The condition is
+// evaluated before every iteration; the counter counts them from 0.
+func (p *Parser) parseWHILE(line int) error {
+	operands, body, err := p.readRepeat("WHILE", line)
+	if err != nil {
+		return err
+	}
+	if len(operands) < 1 || len(operands) > 2 {
+		return directiveError(p.filename, line, "WHILE requires a condition and optional counter")
+	}
+	counter, err := p.repeatCounter(operands, line)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; ; i++ {
+		p.setCounter(counter, i)
+
+		// $ in the condition is the address reached so far
+		p.statementAddr = p.assembler.logicalAddr()
+		cond, err := p.evaluateResolved(operands[0])
+		if err != nil {
+			return err
+		}
+		if cond == 0 {
+			return nil
+		}
+		if i == maxIterations {
+			return rangeError(p.filename, line, "WHILE loop exceeded %d iterations", maxIterations)
+		}
+		if !p.runIteration(body, line, counter, i) {
+			return nil
+		}
+	}
+}
+
+// repeatCounter validates the optional counter operand of a repeat block
+func (p *Parser) repeatCounter(operands []string, line int) (string, error) {
+	if len(operands) < 2 {
+		return "", nil
+	}
+	name := operands[1]
+	if !isSymbolName(name) {
+		return "", directiveError(p.filename, line, "invalid counter name: %s", name)
+	}
+	if sym, exists := p.assembler.symbols[name]; exists && sym.Type != "set" {
+		return "", symbolError(p.filename, line, "counter %s is already defined as %s", name, symbolKind(sym.Type))
+	}
+	return name, nil
+}
+
+// setCounter gives the counter symbol, if any, the iteration number
+func (p *Parser) setCounter(counter string, i int) {
+	if counter == "" {
+		return
+	}
+	p.assembler.setConstant(counter, i, "set")
+}
+
+// runIteration assembles one copy of a repeated body. Labels defined in
+// the body are renamed for the iteration, so each copy has its own. It
+// reports false if the iteration had errors, which stops the loop rather
+// than repeating the same errors on every pass through it.
+func (p *Parser) runIteration(body []string, line int, counter string, i int) bool {
+	a := p.assembler
+	p.setCounter(counter, i)
+
+	a.expansions++
+	values := make(map[string]string)
+	for _, name := range bodyLabels(body) {
+		values[name] = a.uniqueName(name)
+	}
+	lines := make([]string, len(body))
+	for n, text := range body {
+		lines[n] = substituteWords(text, values)
+	}
+
+	errorsBefore := len(a.errors.Errors())
+	parser := NewParser(strings.Join(lines, "\n"), p.debug)
+	parser.assembler = a
+	parser.filename = p.filename
+	parser.line = line + 1
+	parser.expansion = true
+	parser.parseAll()
+
+	return len(a.errors.Errors()) == errorsBefore
+}
+
+// bodyLabels returns the labels defined with a colon in raw source lines,
+// local ones such as .loop included
+func bodyLabels(body []string) []string {
+	var labels []string
+	for _, text := range body {
+		fields := strings.Fields(stripComment(text))
+		if len(fields) == 0 {
+			continue
+		}
+		if name, _, found := strings.Cut(fields[0], ":"); found && isSymbolName(strings.TrimPrefix(name, ".")) {
+			labels = append(labels, name)
+		}
+	}
+	return labels
+}
+
+// indexString returns the position of s in list, or -1
+func indexString(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
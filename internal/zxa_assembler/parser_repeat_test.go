@@ -0,0 +1,77 @@
+// file: internal/zxa_assembler/parser_repeat_test.go
+
+package zxa_assembler
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRepeat(t *testing.T) {
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{
+			name: "REPT",
+			src:  " REPT 3\n nop\n ENDR\n",
+			want: []byte{0, 0, 0},
+		},
+		{
+			name: "REPT with counter",
+			src:  " REPT 4, n\n DEFB n*2\n ENDR\n",
+			want: []byte{0, 2, 4, 6},
+		},
+		{
+			name: "DUP and EDUP",
+			src:  " DUP 2\n DEFB 7\n EDUP\n",
+			want: []byte{7, 7},
+		},
+		{
+			name: "zero count",
+			src:  " REPT 0\n DEFB 1\n ENDR\n DEFB 2\n",
+			want: []byte{2},
+		},
+		{
+			name: "nested",
+			src:  " REPT 2, y\n REPT 2, x\n DEFB y*10+x\n ENDR\n ENDR\n",
+			want: []byte{0, 1, 10, 11},
+		},
+		{
+			name: "labels differ per iteration",
+			src:  " ORG $8000\n REPT 2\nhere: jr here\n ENDR\n",
+			want: []byte{0x18, 0xFE, 0x18, 0xFE},
+		},
+		{
+			name: "WHILE with counter",
+			src:  " WHILE c < 3, c\n DEFB c\n ENDW\n",
+			want: []byte{0, 1, 2},
+		},
+		{
+			name: "semicolons inside strings",
+			src:  " ORG $8000\n REPT 2\nlab: DEFB \";\", low lab\n ENDR\n",
+			want: []byte{';', 0x00, ';', 0x02},
+		},
+		{
+			name:    "label named like an expansion label",
+			src:     "loop__1: nop\n REPT 1\nloop: nop\n ENDR\n",
+			wantErr: "duplicate symbol: loop__1 (also the name a macro or repeat expansion gives a label)",
+		},
+		{name: "ENDR without REPT", src: " ENDR\n", wantErr: "ENDR without REPT"},
+		{name: "unclosed REPT", src: " REPT 2\n nop\n", wantErr: "REPT without ENDR"},
+		{name: "negative count", src: " REPT -1\n ENDR\n", wantErr: "REPT count out of range"},
+		{name: "endless WHILE", src: " WHILE 1\n ENDW\n", wantErr: "WHILE"},
+	})
+}
+
+func TestReportLeavesOutRepeatLabels(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.asm": " ORG $8000\nstart: REPT 2\nloop: jr loop\n ENDR\n"})
+	a := NewAssembler(AssemblerOptions{})
+	a.SetJSONOutput(true)
+	result, err := a.Assemble(filepath.Join(dir, "main.asm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(result.JSONReport, "loop") || !strings.Contains(result.JSONReport, "start") {
+		t.Errorf("report should list start only:\n%s", result.JSONReport)
+	}
+	checkSymbols(t, a, map[string]int{"loop__1": 0x8000, "loop__2": 0x8002})
+}
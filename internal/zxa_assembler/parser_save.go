@@ -0,0 +1,229 @@
+// file: internal/zxa_assembler/parser_save.go
+
+package zxa_assembler
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// saveRequest is an output file asked for by SAVEBIN, SAVETAP, SAVESNA or
+// SAVENEX. The file is built from the memory image once assembly succeeds.
+type saveRequest struct {
+	format string // Directive that asked for the file
+	path   string // Relative to the directory of the source
+	start  int
+	length int
+	entry  int    // SAVESNA and SAVENEX: address to run, -1 for the END one
+	sp     int    // SAVENEX: stack pointer, 0 for the default
+	banks  []int  // SAVENEX: banks to store, nil for those holding code
+	name   string // SAVETAP: name on the tape
+	from   SourceLocation
+}
+
+// saveForms gives the operands each output directive takes, not counting
+// the banks of SAVENEX
+var saveForms = map[string]struct {
+	usage    string
+	min, max int
+}{
+	"SAVEBIN": {`"file", start [,length]`, 2, 3},
+	"SAVETAP": {`"file", start, length [,"name"]`, 3, 4},
+	"SAVESNA": {`"file" [,entry]`, 1, 2},
+	"SAVENEX": {`"file" [,entry [,sp]] [,BANK n ...]`, 1, 3},
+}
+
+// parseSAVE handles the output directives:
+//
+//	SAVEBIN "file", start [,length]                 raw bytes
+//	SAVEBIN "file", BANK n | PAGE n [,length]       raw bytes of a memory page
+//	SAVETAP "file", start, length [,name]           tape with a BASIC loader
+//	SAVESNA "file" [,entry]                         48K snapshot
+//	SAVENEX "file" [,entry [,sp]] [,BANK n ...]     ZX Spectrum Next executable
+//
+// A SAVEBIN without a length runs to the last byte written, or to the end
+// of the page it names. SAVETAP to a file already named appends a block
+// that the loader also loads. Without an entry, snapshots run from the
+// address given by END. SAVENEX stores the banks listed, or else those
+// holding code. Operands are evaluated in the final pass, so they may refer
+// to labels further down, such as the end of the code.
+func (p *Parser) parseSAVE(directive string, line int) error {
+	operands, err := p.readOperands(line)
+	if err != nil {
+		return err
+	}
+
+	// SAVENEX ends with the banks to store
+	var banks []string
+	if directive == "SAVENEX" {
+		for len(operands) > 1 {
+			if _, _, ok := pageOperand(operands[len(operands)-1]); !ok {
+				break
+			}
+			banks = append([]string{operands[len(operands)-1]}, banks...)
+			operands = operands[:len(operands)-1]
+		}
+	}
+
+	// Only the start of SAVEBIN may name a page; SAVENEX banks come last
+	form := saveForms[directive]
+	for i, op := range operands[min(1, len(operands)):] {
+		if _, _, ok := pageOperand(op); ok && (directive != "SAVEBIN" || i != 0) {
+			return directiveError(p.filename, line, "%s requires %s", directive, form.usage)
+		}
+	}
+	if len(operands) < form.min || len(operands) > form.max {
+		return directiveError(p.filename, line, "%s requires %s", directive, form.usage)
+	}
+	filename, ok := stringOperand(operands[0])
+	if !ok || filename == "" {
+		return directiveError(p.filename, line, "%s requires %s", directive, form.usage)
+	}
+	if p.assembler.pass != finalPass {
+		return nil
+	}
+
+	req := saveRequest{
+		format: directive,
+		path:   filename,
+		from:   SourceLocation{File: p.filename, Line: line},
+	}
+	if !filepath.IsAbs(filename) {
+		req.path = filepath.Join(filepath.Dir(p.filename), filename)
+	}
+
+	values := make([]int, len(operands))
+	for i, op := range operands[1:] {
+		if _, isString := stringOperand(op); isString {
+			continue
+		}
+		if _, _, isPage := pageOperand(op); isPage {
+			continue
+		}
+		if values[i+1], err = p.evaluateExpression(op); err != nil {
+			return err
+		}
+	}
+
+	switch directive {
+	case "SAVEBIN", "SAVETAP":
+		// Without a length the range ends where the code does, which is
+		// only known once the whole source has been assembled
+		req.start, req.length = values[1], -1
+		_, _, isPage := pageOperand(operands[1])
+		if isPage {
+			if req.start, req.length, err = p.pageRange(operands[1], line); err != nil {
+				return err
+			}
+		}
+		if len(operands) > 2 {
+			req.length = values[2]
+		}
+		sized := isPage || len(operands) > 2
+		if req.start < 0 || req.start >= memorySize || sized && (req.length < 1 || req.start+req.length > memorySize) {
+			return rangeError(p.filename, line, "%s range $%04X+%d is outside memory",
+				directive, uint16(req.start), req.length)
+		}
+		if directive == "SAVETAP" {
+			if len(operands) > 3 {
+				if req.name, ok = stringOperand(operands[3]); !ok {
+					return directiveError(p.filename, line, "%s requires %s", directive, form.usage)
+				}
+				if len(req.name) > tapeNameLength {
+					return valueError(p.filename, line, "tape name longer than %d characters: %s",
+						tapeNameLength, req.name)
+				}
+			} else {
+				// The file name stands in for a tape name, cut to fit
+				req.name = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+				req.name = req.name[:min(len(req.name), tapeNameLength)]
+			}
+		}
+
+	case "SAVESNA", "SAVENEX":
+		req.entry = -1
+		if len(operands) > 1 {
+			req.entry = values[1]
+		}
+		if len(operands) > 2 {
+			req.sp = values[2]
+		}
+		if len(operands) > 1 && (req.entry < 0 || req.entry >= memorySize) {
+			return rangeError(p.filename, line, "%s entry address out of range: %d", directive, req.entry)
+		}
+		if req.sp < 0 || req.sp >= memorySize {
+			return rangeError(p.filename, line, "%s stack pointer out of range: %d", directive, req.sp)
+		}
+		for _, op := range banks {
+			kind, expr, _ := pageOperand(op)
+			if kind != "BANK" {
+				return directiveError(p.filename, line, "%s stores 16K banks, not %s %s", directive, kind, expr)
+			}
+			start, _, err := p.pageRange(op, line)
+			if err != nil {
+				return err
+			}
+			bank := nexBanks[(start-ramStart)/bankSize]
+			if indexInt(req.banks, bank) >= 0 {
+				return directiveError(p.filename, line, "%s lists bank %d twice", directive, bank)
+			}
+			req.banks = append(req.banks, bank)
+		}
+	}
+
+	p.assembler.saves = append(p.assembler.saves, req)
+	return nil
+}
+
+// pageOperand splits an operand naming a memory page, such as BANK 5 or
+// PAGE 10, into BANK or PAGE and the expression of the number. A label
+// named page used in an expression, as in page+1, is not a page operand.
+func pageOperand(op string) (string, string, bool) {
+	op = strings.TrimSpace(op)
+	i := strings.IndexAny(op, " \t")
+	if i < 0 {
+		return "", "", false
+	}
+	kind, expr := strings.ToUpper(op[:i]), strings.TrimSpace(op[i:])
+	if kind != "BANK" && kind != "PAGE" || strings.ContainsAny(expr[:1], "+-*/%&|^<>=!)") {
+		return "", "", false
+	}
+	return kind, expr, true
+}
+
+// pageRange returns the addresses of the 16K bank or 8K page an operand
+// names. The 64K address space holds the banks the Spectrum maps above its
+// ROM, which are all that can be named.
+func (p *Parser) pageRange(op string, line int) (int, int, error) {
+	kind, expr, _ := pageOperand(op)
+	n, err := p.evaluateExpression(expr)
+	if err != nil {
+		return 0, 0, err
+	}
+	bank, size := n, bankSize
+	if kind == "PAGE" {
+		bank, size = n/2, pageSize
+	}
+	i := indexInt(nexBanks, bank)
+	if n < 0 || i < 0 {
+		if kind == "PAGE" {
+			return 0, 0, rangeError(p.filename, line, "page %d is not in the 64K address space, which holds pages 10, 11, 4, 5, 0 and 1", n)
+		}
+		return 0, 0, rangeError(p.filename, line, "bank %d is not in the 64K address space, which holds banks 5, 2 and 0", n)
+	}
+	start := ramStart + i*bankSize
+	if kind == "PAGE" {
+		start += n % 2 * pageSize
+	}
+	return start, size, nil
+}
+
+// indexInt returns the position of n in list, or -1
+func indexInt(list []int, n int) int {
+	for i, item := range list {
+		if item == n {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,314 @@
+// file: internal/zxa_assembler/parser_structs.go
+
+package zxa_assembler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// structField is one DEFB, DEFW or DEFS field of a structure
+type structField struct {
+	name      string // Empty for unnamed padding
+	directive string
+	offset    int
+	size      int
+	values    []string // Default values, as written
+	fill      byte     // DEFS fill byte
+}
+
+// Struct is a STRUCT definition: a record layout whose field offsets are
+// symbols of their own, such as Player.x
+type Struct struct {
+	Name   string // Qualified name
+	File   string // File holding the definition
+	Line   int    // Line of the STRUCT directive
+	Size   int
+	fields []structField
+}
+
+// parseSTRUCT handles STRUCT name ... ENDS. Each field is written as
+// [name] DEFB|DEFW|DEFS operands and defines name.field as its offset; the
+// structure name itself is defined as the total size.
+func (p *Parser) parseSTRUCT(line int) error {
+	operands, opErr := p.readOperands(line)
+	if opErr != nil {
+		p.discardOperands()
+	}
+	if err := p.endLine(); err != nil {
+		return err
+	}
+	body, ok := p.readBody([]string{"STRUCT"}, []string{"ENDS"})
+	if !ok {
+		return directiveError(p.filename, line, "STRUCT without ENDS")
+	}
+	if opErr != nil {
+		return opErr
+	}
+	if len(operands) != 1 || !isSymbolName(operands[0]) {
+		return directiveError(p.filename, line, "STRUCT requires a name")
+	}
+
+	a := p.assembler
+	s := &Struct{Name: p.qualify(operands[0]), File: p.filename, Line: line}
+	if prev, exists := a.structs[s.Name]; exists {
+		return directiveError(p.filename, line, "struct %s already defined at %s:%d", s.Name, prev.File, prev.Line)
+	}
+	if sym, exists := a.symbols[s.Name]; exists && sym.Type != "struct" {
+		return symbolError(p.filename, line, "struct %s is already defined as %s", s.Name, symbolKind(sym.Type))
+	}
+
+	seen := make(map[string]bool)
+	for i, text := range body {
+		field, ok, err := p.parseField(text, line+1+i, s.Size)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if field.name != "" {
+			if seen[field.name] {
+				return directiveError(p.filename, line+1+i, "duplicate field %s in struct %s", field.name, s.Name)
+			}
+			seen[field.name] = true
+		}
+		s.fields = append(s.fields, field)
+		s.Size += field.size
+	}
+
+	a.structs[s.Name] = s
+	a.setConstant(s.Name, s.Size, "struct")
+	for _, field := range s.fields {
+		if field.name != "" {
+			a.setConstant(s.Name+"."+field.name, field.offset, "field")
+		}
+	}
+	return nil
+}
+
+// parseField reads one line of a STRUCT body. It reports false for a line
+// without a field, such as a comment.
+func (p *Parser) parseField(text string, line, offset int) (structField, bool, error) {
+	parser := NewParser(text, p.debug)
+	parser.assembler = p.assembler
+	parser.filename = p.filename
+	parser.line = line
+
+	field := structField{offset: offset}
+	tok, err := parser.nextToken()
+	if err != nil {
+		return field, false, err
+	}
+	if isEndOfLine(tok) {
+		return field, false, nil
+	}
+
+	// A field may share its name with a register, as in Point.b
+	if tok.Type == TokenIdentifier || tok.Type == TokenRegister {
+		field.name = tok.Value
+		if tok, err = parser.nextToken(); err != nil {
+			return field, false, err
+		}
+		if tok.Type == TokenColon {
+			if tok, err = parser.nextToken(); err != nil {
+				return field, false, err
+			}
+		}
+	}
+
+	field.directive = canonicalDirective(tok.Value)
+	if tok.Type != TokenDirective || !containsString([]string{"DEFB", "DEFW", "DEFS"}, field.directive) {
+		return field, false, directiveError(p.filename, line, "struct fields must be DEFB, DEFW or DEFS: %s", tok.Value)
+	}
+	if field.name != "" && !isSymbolName(field.name) {
+		return field, false, directiveError(p.filename, line, "invalid field name: %s", field.name)
+	}
+
+	operands, err := parser.readOperands(line)
+	if err != nil {
+		return field, false, err
+	}
+
+	switch field.directive {
+	case "DEFB":
+		field.values = operands
+		for _, op := range operands {
+			if str, ok := stringOperand(op); ok {
+				data, err := p.stringBytes(str, line)
+				if err != nil {
+					return field, false, err
+				}
+				field.size += len(data)
+			} else {
+				field.size++
+			}
+		}
+		field.size = max(field.size, 1)
+
+	case "DEFW":
+		field.values = operands
+		field.size = 2 * max(len(operands), 1)
+
+	case "DEFS":
+		if len(operands) < 1 || len(operands) > 2 {
+			return field, false, directiveError(p.filename, line, "DEFS requires size")
+		}
+		if field.size, err = p.evaluateResolved(operands[0]); err != nil {
+			return field, false, err
+		}
+		if field.size < 0 {
+			return field, false, valueError(p.filename, line, "negative DEFS size: %d", field.size)
+		}
+		if len(operands) == 2 {
+			fill, err := p.evaluateResolved(operands[1])
+			if err != nil {
+				return field, false, err
+			}
+			if fill < -128 || fill > 255 {
+				return field, false, valueError(p.filename, line, "invalid DEFS fill value: %d", fill)
+			}
+			field.fill = byte(fill)
+		}
+	}
+
+	return field, true, nil
+}
+
+// lookupStruct returns the structure a statement keyword instantiates, if any
+func (p *Parser) lookupStruct(name string) (*Struct, bool) {
+	for _, full := range p.candidates(name) {
+		if s, ok := p.assembler.structs[full]; ok {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// instantiate emits one instance of a structure. Values are given in field
+// order, or as field=value, and replace the defaults of their fields. A
+// label on the line also gets a symbol for each field, as in player.x.
+func (p *Parser) instantiate(s *Struct, token Token) error {
+	line := token.Line
+	args, err := p.readOperands(line)
+	if err != nil {
+		return err
+	}
+
+	// Match the values to named fields, as macro arguments are matched
+	var named []int
+	for i, field := range s.fields {
+		if field.name != "" {
+			named = append(named, i)
+		}
+	}
+	values := make(map[int]string)
+	for i, arg := range args {
+		index := -1
+		if name, value, ok := strings.Cut(arg, "="); ok && !strings.HasPrefix(value, "=") {
+			name = strings.TrimSpace(name)
+			if index = s.fieldIndex(name); index >= 0 {
+				arg = strings.TrimSpace(value)
+			} else if isSymbolName(name) {
+				return directiveError(p.filename, line, "no field %s in struct %s", name, s.Name)
+			}
+		}
+		if index < 0 {
+			if i >= len(named) {
+				return directiveError(p.filename, line, "too many values for struct %s (has %d fields)",
+					s.Name, len(named))
+			}
+			index = named[i]
+		}
+		if _, set := values[index]; set {
+			return directiveError(p.filename, line, "field %s of struct %s given twice", s.fields[index].name, s.Name)
+		}
+		values[index] = arg
+	}
+
+	start := p.assembler.logicalAddr()
+	for i, field := range s.fields {
+		data, err := p.fieldBytes(s, field, values[i], values[i] != "")
+		if err != nil {
+			return err
+		}
+		for _, b := range data {
+			p.assembler.emitByte(b)
+		}
+	}
+
+	if p.statementLabel != "" {
+		for _, field := range s.fields {
+			if field.name == "" {
+				continue
+			}
+			if err := p.assembler.addSymbol(p.statementLabel+"."+field.name, start+field.offset); err != nil {
+				return symbolError(p.filename, line, "%v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// fieldBytes returns the bytes of one field of an instance, padded to the
+// size of the field
+func (p *Parser) fieldBytes(s *Struct, field structField, value string, given bool) ([]byte, error) {
+	values := field.values
+	if given {
+		values = []string{value}
+	}
+
+	var data []byte
+	for _, op := range values {
+		if str, ok := stringOperand(op); ok && field.directive != "DEFW" {
+			bytes, err := p.stringBytes(str, p.statementLine)
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, bytes...)
+			continue
+		}
+		n, err := p.evaluateExpression(op)
+		if err != nil {
+			return nil, err
+		}
+		if field.directive == "DEFW" {
+			if n < -32768 || n > 65535 {
+				return nil, valueError(p.filename, p.statementLine, "DEFW value out of range: %d", n)
+			}
+			data = append(data, byte(n), byte(n>>8))
+			continue
+		}
+		if n < -128 || n > 255 {
+			return nil, valueError(p.filename, p.statementLine, "DEFB value out of range: %d", n)
+		}
+		data = append(data, byte(n))
+	}
+
+	if len(data) > field.size {
+		return nil, valueError(p.filename, p.statementLine, "value for %s is %d bytes, the field holds %d",
+			fieldName(s, field), len(data), field.size)
+	}
+	for len(data) < field.size {
+		data = append(data, field.fill)
+	}
+	return data, nil
+}
+
+// fieldIndex returns the position of the named field, or -1
+func (s *Struct) fieldIndex(name string) int {
+	for i, field := range s.fields {
+		if field.name != "" && field.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// fieldName names a field in messages
+func fieldName(s *Struct, field structField) string {
+	if field.name == "" {
+		return fmt.Sprintf("%s+%d", s.Name, field.offset)
+	}
+	return s.Name + "." + field.name
+}
@@ -0,0 +1,67 @@
+// file: internal/zxa_assembler/parser_structs_test.go
+
+package zxa_assembler
+
+import "testing"
+
+const playerStruct = ` STRUCT Player
+x DEFB 0
+y DEFB 0
+hp DEFW 100
+name DEFS 3, 2DH
+ ENDS
+`
+
+func TestStructOffsets(t *testing.T) {
+	a, _, err := assembleSource(t, playerStruct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSymbols(t, a, map[string]int{"Player": 7, "Player.x": 0, "Player.y": 1, "Player.hp": 2, "Player.name": 4})
+}
+
+func TestStructInstances(t *testing.T) {
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{
+			name: "defaults",
+			src:  playerStruct + " ORG $8000\np1: Player\n",
+			want: []byte{0, 0, 100, 0, '-', '-', '-'},
+		},
+		{
+			name: "positional values",
+			src:  playerStruct + "p1: Player 1, 2, $1234\n",
+			want: []byte{1, 2, 0x34, 0x12, '-', '-', '-'},
+		},
+		{
+			name: "named values",
+			src:  playerStruct + "p1: Player hp=5, y=9\n",
+			want: []byte{0, 9, 5, 0, '-', '-', '-'},
+		},
+		{
+			name: "comparison as a positional value",
+			src:  playerStruct + "p1: Player 2<=3, 3>=2\n",
+			want: []byte{1, 1, 100, 0, '-', '-', '-'},
+		},
+		{
+			name: "string shorter than its field is padded",
+			src:  playerStruct + "p1: Player 0, 0, 0, \"ab\"\n",
+			want: []byte{0, 0, 0, 0, 'a', 'b', '-'},
+		},
+		{
+			name: "field labels of an instance",
+			src:  playerStruct + " ORG $8000\n DEFB 0\np1: Player\n ld a, (p1.y)\n ld hl, Player\n",
+			want: []byte{0, 0, 0, 100, 0, '-', '-', '-', 0x3A, 0x02, 0x80, 0x21, 0x07, 0x00},
+		},
+		{
+			name: "field offset in an indexed operand",
+			src:  playerStruct + " ld a, (ix+Player.hp)\n",
+			want: []byte{0xDD, 0x7E, 0x02},
+		},
+		{name: "too many values", src: playerStruct + " Player 1, 2, 3, 4, 5\n", wantErr: "too many values for struct Player"},
+		{name: "field given twice", src: playerStruct + " Player 1, x=2\n", wantErr: "field x of struct Player given twice"},
+		{name: "unknown field", src: playerStruct + "p: Player junk=7\n", wantErr: "no field junk in struct Player"},
+		{name: "string too long", src: playerStruct + " Player 0, 0, 0, \"abcd\"\n", wantErr: "name"},
+		{name: "without ENDS", src: " STRUCT S\nx DEFB 0\n", wantErr: "STRUCT without ENDS"},
+		{name: "ENDS alone", src: " ENDS\n", wantErr: "ENDS without STRUCT"},
+	})
+}
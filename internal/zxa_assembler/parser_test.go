@@ -0,0 +1,60 @@
+// file: internal/zxa_assembler/parser_test.go
+
+package zxa_assembler
+
+import "testing"
+
+func TestLineStructure(t *testing.T) {
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{
+			name: "instructions without operands stay on their line",
+			src:  " ret\n nop\n ei\n",
+			want: []byte{0xC9, 0x00, 0xFB},
+		},
+		{
+			name: "conditional return before a label",
+			src:  " ret nz\nnext: ret\n",
+			want: []byte{0xC0, 0xC9},
+		},
+		{
+			name: "comments",
+			src:  "; header\n nop ; trailing\n\n DEFB \"a;b\" ; a semicolon in quotes\n",
+			want: []byte{0x00, 'a', ';', 'b'},
+		},
+		{
+			name: "label alone on a line",
+			src:  " ORG $8000\nloop:\n jr loop\n",
+			want: []byte{0x18, 0xFE},
+		},
+		{
+			name: "label and instruction",
+			src:  " ORG $8000\nstart: ld a, 1\n jp start\n",
+			want: []byte{0x3E, 0x01, 0xC3, 0x00, 0x80},
+		},
+		{
+			name: "no final newline",
+			src:  " nop\n halt",
+			want: []byte{0x00, 0x76},
+		},
+		{
+			name: "CRLF line endings",
+			src:  "start: ld a, 1 ; one\r\n DEFB \"x\", \"y\"\r\n jr start\r\n",
+			want: []byte{0x3E, 0x01, 'x', 'y', 0x18, 0xFA},
+		},
+		{
+			name: "CRLF in macro and repeat bodies",
+			src:  " MACRO m\r\n DEFB 1\r\n ENDM\r\n m\r\n REPT 2\r\n DEFB 2\r\n ENDR\r\n",
+			want: []byte{1, 2, 2},
+		},
+		{
+			name:    "two instructions on one line",
+			src:     " nop nop\n",
+			wantErr: "main.asm:1:",
+		},
+		{
+			name:    "error line number",
+			src:     " nop\n\n ld a, 999\n",
+			wantErr: "main.asm:3:",
+		},
+	})
+}
@@ -0,0 +1,123 @@
+// file: internal/zxa_assembler/undocumented_instructions.go
+
+package zxa_assembler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// indexHalves lists the 8-bit halves of IX and IY, which the DD/FD prefix
+// substitutes for H and L
+var indexHalves = map[string]string{
+	"IXH": "IX", "IXL": "IX",
+	"IYH": "IY", "IYL": "IY",
+}
+
+// initUndocumentedInstructions adds the undocumented Z80 instructions. They
+// run on every real Z80 but are only assembled when the option is enabled.
+func (m InstructionMap) initUndocumentedInstructions() {
+	for _, ix := range indexRegs {
+		x, prefix := ix.name, ix.prefix
+		halves := [2]string{x + "H", x + "L"}
+
+		// 8-bit loads with the index register halves in place of H and L
+		for r, reg := range regs8 {
+			if r == 4 || r == 5 || r == 6 {
+				continue
+			}
+			for h, half := range halves {
+				m["LD "+reg+","+half] = Instruction{byte(0x44 | r<<3 | h), prefix, Register, 2, 8, false}
+				m["LD "+half+","+reg] = Instruction{byte(0x60 | (4+h)<<3 | r), prefix, Register, 2, 8, false}
+			}
+		}
+		for d, dst := range halves {
+			for s, src := range halves {
+				m["LD "+dst+","+src] = Instruction{byte(0x64 | d<<3 | s), prefix, Register, 2, 8, false}
+			}
+			m["LD "+dst+",n"] = Instruction{byte(0x26 | d<<3), prefix, Immediate, 3, 11, false}
+			m["INC "+dst] = Instruction{byte(0x24 | d<<3), prefix, Register, 2, 8, false}
+			m["DEC "+dst] = Instruction{byte(0x25 | d<<3), prefix, Register, 2, 8, false}
+		}
+
+		// Arithmetic and logic on the halves
+		for op, alu := range aluOps {
+			for h, half := range halves {
+				m[alu+half] = Instruction{byte(0x84 | op<<3 | h), prefix, Register, 2, 8, false}
+			}
+		}
+
+		mem := "(" + x + "+d)"
+		m["SLL "+mem] = Instruction{0x36, prefix, IndexedBit, 4, 23, false}
+
+		// DDCB/FDCB operations that also copy the result into a register
+		for r, reg := range regs8 {
+			if r == 6 {
+				continue
+			}
+			for op, name := range cbShiftOps {
+				if name == "" {
+					name = "SLL"
+				}
+				m[name+" "+mem+","+reg] = Instruction{byte(op<<3 | r), prefix, IndexedBit, 4, 23, false}
+			}
+			for bit := 0; bit < 8; bit++ {
+				m[fmt.Sprintf("RES %d,%s,%s", bit, mem, reg)] = Instruction{byte(0x80 | bit<<3 | r), prefix, IndexedBit, 4, 23, false}
+				m[fmt.Sprintf("SET %d,%s,%s", bit, mem, reg)] = Instruction{byte(0xC0 | bit<<3 | r), prefix, IndexedBit, 4, 23, false}
+			}
+		}
+	}
+
+	// Shift left inserting a one (SLL, also written SLI)
+	for r, reg := range regs8 {
+		cycles, mode := 8, Register
+		if r == 6 {
+			cycles, mode = 15, RegisterIndirect
+		}
+		m["SLL "+reg] = Instruction{byte(0x30 | r), 0xCB, mode, 2, cycles, false}
+	}
+
+	// I/O that only affects the flags or always writes zero
+	m["IN F,(C)"] = Instruction{0x70, 0xED, RegisterIndirect, 2, 12, false}
+	m["IN (C)"] = Instruction{0x70, 0xED, RegisterIndirect, 2, 12, false}
+	m["OUT (C),0"] = Instruction{0x71, 0xED, RegisterIndirect, 2, 12, false}
+
+	// SLI is another common spelling of SLL
+	for key, inst := range m {
+		if strings.HasPrefix(key, "SLL ") {
+			m["SLI "+key[4:]] = inst
+		}
+	}
+}
+
+// checkIndexHalves explains why operands using IXH, IXL, IYH or IYL cannot
+// be encoded. One prefix byte selects IX or IY for the whole instruction,
+// so halves of both registers cannot meet, and H, L or (HL) cannot appear
+// next to a half because the prefix would turn them into halves as well.
+func checkIndexHalves(operands []string) error {
+	half, family := "", ""
+	for _, op := range operands {
+		upper := strings.ToUpper(op)
+		if f, ok := indexHalves[upper]; ok {
+			if family != "" && f != family {
+				return fmt.Errorf("cannot combine %s with %s: IX and IY halves need different prefixes", half, upper)
+			}
+			half, family = upper, f
+		}
+	}
+	if half == "" {
+		return nil
+	}
+
+	for _, op := range operands {
+		upper := strings.ToUpper(op)
+		switch {
+		case upper == "H", upper == "L", upper == "(HL)":
+			return fmt.Errorf("cannot combine %s with %s: the %s prefix would also apply to %s",
+				half, upper, family, upper)
+		case isIndirect(upper) && (strings.HasPrefix(upper, "(IX") || strings.HasPrefix(upper, "(IY")):
+			return fmt.Errorf("cannot combine %s with indexed memory operand %s", half, op)
+		}
+	}
+	return nil
+}
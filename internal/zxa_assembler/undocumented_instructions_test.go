@@ -0,0 +1,36 @@
+// file: internal/zxa_assembler/undocumented_instructions_test.go
+
+package zxa_assembler
+
+import "testing"
+
+func TestUndocumentedInstructions(t *testing.T) {
+	runInstructions(t, AssemblerOptions{Undocumented: true}, []instructionCase{
+		{"ld a, ixh", []byte{0xDD, 0x7C}},
+		{"ld iyl, b", []byte{0xFD, 0x68}},
+		{"ld ixh, ixl", []byte{0xDD, 0x65}},
+		{"ld ixl, $12", []byte{0xDD, 0x2E, 0x12}},
+		{"inc iyh", []byte{0xFD, 0x24}},
+		{"dec ixl", []byte{0xDD, 0x2D}},
+		{"add a, ixl", []byte{0xDD, 0x85}},
+		{"cp iyh", []byte{0xFD, 0xBC}},
+		{"sll a", []byte{0xCB, 0x37}},
+		{"sli (hl)", []byte{0xCB, 0x36}},
+		{"sll (ix+2)", []byte{0xDD, 0xCB, 0x02, 0x36}},
+		{"rlc (iy+1), b", []byte{0xFD, 0xCB, 0x01, 0x00}},
+		{"set 1, (ix+0), a", []byte{0xDD, 0xCB, 0x00, 0xCF}},
+		{"in f, (c)", []byte{0xED, 0x70}},
+		{"out (c), 0", []byte{0xED, 0x71}},
+	})
+}
+
+func TestUndocumentedInstructionErrors(t *testing.T) {
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{name: "disabled", src: " sll a\n", wantErr: "undocumented instruction"},
+		{name: "disabled half", src: " ld a, ixh\n", wantErr: "undocumented instruction"},
+	})
+	runCases(t, AssemblerOptions{Undocumented: true}, []asmCase{
+		{name: "mixed halves", src: " ld ixh, iyl\n", wantErr: "IX and IY halves need different prefixes"},
+		{name: "half with H", src: " ld ixh, h\n", wantErr: "prefix would also apply to H"},
+	})
+}
@@ -0,0 +1,34 @@
+// file: internal/zxa_assembler/z80n_instructions_test.go
+
+package zxa_assembler
+
+import "testing"
+
+func TestZ80NInstructions(t *testing.T) {
+	runInstructions(t, AssemblerOptions{Variant: Z80Next}, []instructionCase{
+		{"swapnib", []byte{0xED, 0x23}},
+		{"mirror a", []byte{0xED, 0x24}},
+		{"test $0F", []byte{0xED, 0x27, 0x0F}},
+		{"bsla de, b", []byte{0xED, 0x28}},
+		{"brlc de, b", []byte{0xED, 0x2C}},
+		{"mul d, e", []byte{0xED, 0x30}},
+		{"add hl, a", []byte{0xED, 0x31}},
+		{"add de, $1234", []byte{0xED, 0x35, 0x34, 0x12}},
+		{"push $1234", []byte{0xED, 0x8A, 0x12, 0x34}},
+		{"outinb", []byte{0xED, 0x90}},
+		{"nextreg $07, $03", []byte{0xED, 0x91, 0x07, 0x03}},
+		{"nextreg $07, a", []byte{0xED, 0x92, 0x07}},
+		{"pixeldn", []byte{0xED, 0x93}},
+		{"setae", []byte{0xED, 0x95}},
+		{"jp (c)", []byte{0xED, 0x98}},
+		{"ldirx", []byte{0xED, 0xB4}},
+		{"lddrx", []byte{0xED, 0xBC}},
+	})
+}
+
+func TestZ80NDisabled(t *testing.T) {
+	runCases(t, AssemblerOptions{}, []asmCase{
+		{name: "swapnib", src: " swapnib\n", wantErr: "unknown instruction or directive: swapnib"},
+		{name: "nextreg", src: " nextreg 7, 3\n", wantErr: "unknown instruction or directive: nextreg"},
+	})
+}